@@ -28,6 +28,7 @@ package bson
 
 import (
 	"crypto/md5"
+	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
@@ -84,6 +85,103 @@ type Setter interface {
 	SetBSON(raw Raw) error
 }
 
+// A value implementing the bson.Validator interface has its Validate
+// method called right after Marshal finishes encoding it, or right after
+// Unmarshal or Raw.Unmarshal finishes populating it, depending on the
+// current validation mode (see SetValidationMode). This mirrors Getter
+// and Setter in letting a type enforce its own invariants at the point
+// where it's serialized, rather than leaving callers to remember to do
+// it themselves.
+//
+// If Validate returns a non-nil error, the marshalling or unmarshalling
+// procedure stops and errors out with the provided value.
+type Validator interface {
+	Validate() error
+}
+
+// ValidationMode selects when Marshal and Unmarshal automatically invoke
+// the Validator interface. See SetValidationMode.
+type ValidationMode int
+
+const (
+	// ValidateNone disables automatic validation. This is the default.
+	ValidateNone ValidationMode = 0
+	// ValidateOnUnmarshal calls Validate after Unmarshal or Raw.Unmarshal
+	// populates a value that implements Validator.
+	ValidateOnUnmarshal ValidationMode = 1
+	// ValidateOnMarshal calls Validate on a value that implements
+	// Validator after Marshal encodes it.
+	ValidateOnMarshal ValidationMode = 2
+	// ValidateBoth enables both ValidateOnMarshal and ValidateOnUnmarshal.
+	ValidateBoth = ValidateOnMarshal | ValidateOnUnmarshal
+)
+
+var (
+	validationMode      = ValidateNone
+	validationModeMutex sync.RWMutex
+)
+
+// SetValidationMode controls whether Marshal and Unmarshal automatically
+// call Validate on values implementing the Validator interface. Callers
+// that don't want the overhead can leave it at the default, ValidateNone.
+func SetValidationMode(mode ValidationMode) {
+	validationModeMutex.Lock()
+	validationMode = mode
+	validationModeMutex.Unlock()
+}
+
+func currentValidationMode() ValidationMode {
+	validationModeMutex.RLock()
+	defer validationModeMutex.RUnlock()
+	return validationMode
+}
+
+// validateValue calls Validate on v and on any nested inline struct
+// discovered via getStructInfo, unless the field was tagged ",novalidate".
+// Pointers and interfaces are dereferenced first; nil values and non-struct
+// values are silently ignored.
+func validateValue(v reflect.Value) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	if err := callValidate(v); err != nil {
+		return err
+	}
+	sinfo, err := getStructInfo(v.Type())
+	if err != nil {
+		return nil
+	}
+	seen := make(map[int]bool)
+	for _, finfo := range sinfo.FieldsList {
+		if finfo.Inline == nil || finfo.NoValidate || seen[finfo.Inline[0]] {
+			continue
+		}
+		seen[finfo.Inline[0]] = true
+		if err := validateValue(v.Field(finfo.Inline[0])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func callValidate(v reflect.Value) error {
+	if v.CanAddr() {
+		if val, ok := v.Addr().Interface().(Validator); ok {
+			return val.Validate()
+		}
+	}
+	if val, ok := v.Interface().(Validator); ok {
+		return val.Validate()
+	}
+	return nil
+}
+
 // M is a convenient alias for a map[string]interface{} map, useful for
 // dealing with BSON in a native way.  For instance:
 //
@@ -154,45 +252,86 @@ func ObjectIdHex(s string) ObjectId {
 
 // objectIdCounter is atomically incremented when generating a new ObjectId
 // using NewObjectId() function. It's used as a counter part of an id.
-var objectIdCounter uint32 = 0
-
-// machineId stores machine id generated once and used in subsequent calls
-// to NewObjectId function.
-var machineId []byte
-
-// initMachineId generates machine id and puts it into the machineId global
-// variable. If this function fails to get the hostname, it will cause
-// a runtime error.
-func initMachineId() {
-	var sum [3]byte
-	hostname, err := os.Hostname()
-	if err != nil {
-		panic("Failed to get hostname: " + err.Error())
+// It starts from a crypto/rand-seeded value rather than zero so that
+// restarting the process doesn't reissue the same sequence of ids.
+var objectIdCounter uint32
+
+// processUnique stores the 5-byte "process unique" value generated once
+// and used in subsequent calls to the NewObjectId function, following
+// the newer ObjectId layout of 4-byte timestamp, 5-byte process unique
+// and 3-byte counter. This replaces the older 3-byte machine id plus
+// 2-byte pid split, which collided across containers sharing a hostname
+// and wrapped the pid field in single-process container workloads.
+var processUnique []byte
+
+var processUniqueOnce sync.Once
+
+// objectIdGenerator, when set with SetObjectIdGenerator, replaces the
+// default NewObjectId implementation. It holds a func() ObjectId wrapped
+// in the objectIdGeneratorFunc type, accessed through an atomic.Value so
+// that SetObjectIdGenerator can safely race with concurrent calls to
+// NewObjectId.
+var objectIdGenerator atomic.Value
+
+type objectIdGeneratorFunc func() ObjectId
+
+// SetObjectIdGenerator replaces the function used by NewObjectId to
+// produce new ids, so that callers can plug in a Snowflake-style or
+// otherwise externally coordinated generator. Passing nil restores the
+// default generator. It's safe to call concurrently with NewObjectId.
+func SetObjectIdGenerator(gen func() ObjectId) {
+	if gen == nil {
+		objectIdGenerator.Store(objectIdGeneratorFunc(nil))
+		return
+	}
+	objectIdGenerator.Store(objectIdGeneratorFunc(gen))
+}
+
+// initProcessUnique generates the process-unique value and seeds
+// objectIdCounter, both from crypto/rand. If crypto/rand fails, which
+// should never happen in practice, it falls back to the older
+// hostname-hash scheme for the process-unique value and leaves the
+// counter starting from zero.
+func initProcessUnique() {
+	var b [5]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		hostname, herr := os.Hostname()
+		if herr != nil {
+			panic("Failed to get hostname: " + herr.Error())
+		}
+		hw := md5.New()
+		hw.Write([]byte(hostname))
+		copy(b[:3], hw.Sum(nil))
+		pid := os.Getpid()
+		b[3] = byte(pid >> 8)
+		b[4] = byte(pid)
+	}
+	processUnique = b[:]
+
+	var cb [4]byte
+	if _, err := rand.Read(cb[:]); err == nil {
+		atomic.StoreUint32(&objectIdCounter, binary.BigEndian.Uint32(cb[:])&0xffffff)
 	}
-	hw := md5.New()
-	hw.Write([]byte(hostname))
-	copy(sum[:3], hw.Sum(nil))
-	machineId = sum[:]
+}
+
+// ProcessUnique returns the 5-byte random value generated once per
+// process and embedded in every ObjectId produced by NewObjectId.
+func ProcessUnique() []byte {
+	processUniqueOnce.Do(initProcessUnique)
+	return processUnique
 }
 
 // NewObjectId returns a new unique ObjectId.
-// This function causes a runtime error if it fails to get the hostname
-// of the current machine.
 func NewObjectId() ObjectId {
+	if gen, _ := objectIdGenerator.Load().(objectIdGeneratorFunc); gen != nil {
+		return gen()
+	}
 	b := make([]byte, 12)
 	// Timestamp, 4 bytes, big endian
 	binary.BigEndian.PutUint32(b, uint32(time.Now().Unix()))
-	// Machine, first 3 bytes of md5(hostname)
-	if machineId == nil {
-		initMachineId()
-	}
-	b[4] = machineId[0]
-	b[5] = machineId[1]
-	b[6] = machineId[2]
-	// Pid, 2 bytes, specs don't specify endianness, but we use big endian.
-	pid := os.Getpid()
-	b[7] = byte(pid >> 8)
-	b[8] = byte(pid)
+	// Process unique, 5 bytes
+	processUniqueOnce.Do(initProcessUnique)
+	copy(b[4:9], processUnique)
 	// Increment, 3 bytes, big endian
 	i := atomic.AddUint32(&objectIdCounter, 1)
 	b[9] = byte(i >> 16)
@@ -264,13 +403,18 @@ func (id ObjectId) Time() time.Time {
 	return time.Unix(secs, 0)
 }
 
-// Machine returns the 3-byte machine id part of the id.
+// Machine returns the first 3 bytes of the process-unique part of the
+// id. It's kept for backward compatibility with the older machine id
+// scheme; use ProcessUnique for the full 5-byte value.
 // It's a runtime error to call this method with an invalid id.
 func (id ObjectId) Machine() []byte {
 	return id.byteSlice(4, 7)
 }
 
-// Pid returns the process id part of the id.
+// Pid returns the last 2 bytes of the process-unique part of the id,
+// reinterpreted as a big-endian uint16. It's kept for backward
+// compatibility with the older layout; ids generated by this version no
+// longer embed the actual process id.
 // It's a runtime error to call this method with an invalid id.
 func (id ObjectId) Pid() uint16 {
 	return binary.BigEndian.Uint16(id.byteSlice(7, 9))
@@ -409,6 +553,23 @@ func Marshal(in interface{}) (out []byte, err error) {
 	defer handleErr(&err)
 	e := &encoder{make([]byte, 0, initialBufferSize)}
 	e.addDoc(reflect.ValueOf(in))
+	if currentValidationMode()&ValidateOnMarshal != 0 {
+		v := reflect.ValueOf(in)
+		// reflect.ValueOf never yields an addressable Value, so a struct
+		// passed by value would never be seen as implementing Validator
+		// via a pointer receiver. Copy it into an addressable location
+		// before validating so pointer-receiver Validate methods - the
+		// idiomatic form - are honored regardless of how the caller
+		// passed the value in.
+		if v.IsValid() && v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface {
+			addr := reflect.New(v.Type())
+			addr.Elem().Set(v)
+			v = addr
+		}
+		if err := validateValue(v); err != nil {
+			return nil, err
+		}
+	}
 	return e.out, nil
 }
 
@@ -442,6 +603,9 @@ func Unmarshal(in []byte, out interface{}) (err error) {
 	default:
 		return errors.New("Unmarshal needs a map or a pointer to a struct.")
 	}
+	if currentValidationMode()&ValidateOnUnmarshal != 0 {
+		return validateValue(v)
+	}
 	return nil
 }
 
@@ -468,6 +632,9 @@ func (raw Raw) Unmarshal(out interface{}) (err error) {
 	default:
 		return errors.New("Raw Unmarshal needs a map or a valid pointer.")
 	}
+	if currentValidationMode()&ValidateOnUnmarshal != 0 {
+		return validateValue(v)
+	}
 	return nil
 }
 
@@ -490,11 +657,12 @@ type structInfo struct {
 }
 
 type fieldInfo struct {
-	Key       string
-	Num       int
-	OmitEmpty bool
-	MinSize   bool
-	Inline    []int
+	Key        string
+	Num        int
+	OmitEmpty  bool
+	MinSize    bool
+	Inline     []int
+	NoValidate bool
 }
 
 var structMap = make(map[reflect.Type]*structInfo)
@@ -561,6 +729,8 @@ func getStructInfo(st reflect.Type) (*structInfo, error) {
 					info.MinSize = true
 				case "inline":
 					inline = true
+				case "novalidate":
+					info.NoValidate = true
 				default:
 					msg := fmt.Sprintf("Unsupported flag %q in tag %q of type %s", flag, tag, st)
 					panic(externalPanic(msg))
@@ -587,6 +757,9 @@ func getStructInfo(st reflect.Type) (*structInfo, error) {
 				} else {
 					finfo.Inline = append([]int{i}, finfo.Inline...)
 				}
+				if info.NoValidate {
+					finfo.NoValidate = true
+				}
 				fieldsMap[finfo.Key] = finfo
 				fieldsList = append(fieldsList, finfo)
 			}
@@ -0,0 +1,360 @@
+package bson
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Decimal128 holds decimal128 BSON values, as specified in
+// https://github.com/mongodb/specifications/blob/master/source/bson-decimal128/decimal128.rst
+//
+// Decimal128 supports 34 decimal digits of precision and an exponent range
+// of -6176 to +6111, matching the IEEE 754-2008 decimal128 type. The value
+// is stored as the raw low/high 64-bit halves of its 128-bit encoding, so
+// Decimal128 values must be built with ParseDecimal128 rather than
+// composite literals.
+type Decimal128 struct {
+	l, h uint64
+}
+
+var decimal128Type = reflect.TypeOf(Decimal128{})
+
+// Special bit patterns identifying the decimal128 special values. The five
+// bits after the sign bit are 11110 for infinities and 11111 for NaNs,
+// per the combination field layout described in the IEEE 754-2008 decimal
+// interchange format.
+var (
+	dec128PosInfinity = Decimal128{h: 0x7800000000000000}
+	dec128NegInfinity = Decimal128{h: 0xf800000000000000}
+	dec128NaN         = Decimal128{h: 0x7c00000000000000}
+)
+
+const (
+	dec128ExponentBias = 6176
+	dec128ExponentMax  = 6111
+	dec128ExponentMin  = -6176
+	dec128MaxDigits    = 34
+)
+
+// dec128Low110Mask selects the 110-bit coefficient continuation field that
+// follows the 5-bit combination field and 12-bit exponent continuation
+// field in the decimal128 bit layout.
+var dec128Low110Mask = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 110), big.NewInt(1))
+
+// ParseDecimal128 parses a decimal128 value from its string representation,
+// as produced by Decimal128.String. Accepted inputs are an optional sign
+// followed by a run of decimal digits with an optional '.' and an optional
+// exponent introduced by 'e' or 'E', or one of the special values "NaN",
+// "Inf", "Infinity", "-Inf" and "-Infinity" (case insensitive).
+func ParseDecimal128(s string) (Decimal128, error) {
+	orig := s
+	if s == "" {
+		return Decimal128{}, fmt.Errorf("bson: cannot parse %q as decimal128", orig)
+	}
+
+	neg := false
+	if s[0] == '+' || s[0] == '-' {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+
+	switch strings.ToLower(s) {
+	case "nan":
+		return dec128NaN, nil
+	case "inf", "infinity":
+		if neg {
+			return dec128NegInfinity, nil
+		}
+		return dec128PosInfinity, nil
+	}
+
+	if s == "" {
+		return Decimal128{}, fmt.Errorf("bson: cannot parse %q as decimal128", orig)
+	}
+
+	var exp int
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(s[i+1:])
+		if err != nil {
+			return Decimal128{}, fmt.Errorf("bson: cannot parse %q as decimal128: bad exponent", orig)
+		}
+		exp = e
+		s = s[:i]
+	}
+
+	digits := s
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		frac := s[i+1:]
+		digits = s[:i] + frac
+		exp -= len(frac)
+	}
+	if digits == "" {
+		return Decimal128{}, fmt.Errorf("bson: cannot parse %q as decimal128", orig)
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return Decimal128{}, fmt.Errorf("bson: cannot parse %q as decimal128", orig)
+		}
+	}
+
+	// Strip leading zeros, but keep at least one digit.
+	digits = strings.TrimLeft(digits, "0")
+	if digits == "" {
+		digits = "0"
+	}
+
+	coeff, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return Decimal128{}, fmt.Errorf("bson: cannot parse %q as decimal128", orig)
+	}
+
+	// Round to at most 34 significant digits, half-even, adjusting the
+	// exponent to compensate for any digits dropped in the process.
+	if len(digits) > dec128MaxDigits {
+		drop := len(digits) - dec128MaxDigits
+		coeff, exp = roundDecimalCoeff(coeff, drop, exp)
+	}
+
+	return newDecimal128(neg, exp, coeff)
+}
+
+// roundDecimalCoeff drops the low n digits of coeff, rounding half-even,
+// and returns the adjusted coefficient together with exp bumped by n to
+// preserve the represented value. If rounding up carries the coefficient
+// past 34 digits (e.g. a run of nines rounding to a power of ten), the
+// result is renormalized by dropping one more digit and bumping exp again.
+func roundDecimalCoeff(coeff *big.Int, n int, exp int) (*big.Int, int) {
+	div := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+	half := new(big.Int).Rsh(div, 1)
+	q, r := new(big.Int).QuoRem(coeff, div, new(big.Int))
+	switch r.CmpAbs(half) {
+	case 1:
+		q.Add(q, big.NewInt(1))
+	case 0:
+		if q.Bit(0) == 1 {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	exp += n
+	if len(q.String()) > dec128MaxDigits {
+		q, exp = roundDecimalCoeff(q, 1, exp)
+	}
+	return q, exp
+}
+
+// newDecimal128 packs a sign, power-of-ten exponent and non-negative
+// coefficient (at most 34 decimal digits) into its decimal128 bit layout.
+func newDecimal128(neg bool, exp int, coeff *big.Int) (Decimal128, error) {
+	if exp > dec128ExponentMax || exp < dec128ExponentMin {
+		return Decimal128{}, fmt.Errorf("bson: decimal128 exponent %d out of range", exp)
+	}
+	if coeff.BitLen() > 113 {
+		return Decimal128{}, errors.New("bson: decimal128 coefficient out of range")
+	}
+
+	biased := exp + dec128ExponentBias
+
+	// Case 1 layout: the 5-bit combination field holds the top 2 bits of
+	// the 14-bit biased exponent followed by the top 3 bits of the
+	// 113-bit coefficient, and only then do the remaining 12 exponent
+	// bits and 110 coefficient bits follow as separate continuation
+	// fields — the two fields are interleaved, not laid out as a
+	// contiguous exponent followed by a contiguous coefficient. Every
+	// valid decimal128 coefficient (<= 34 digits) fits this layout,
+	// since its top 3 bits never exceed 0b111.
+	expTop2 := big.NewInt(int64(biased >> 12))
+	expLow12 := big.NewInt(int64(biased & 0xFFF))
+	coeffTop3 := new(big.Int).Rsh(coeff, 110)
+	coeffLow110 := new(big.Int).And(coeff, dec128Low110Mask)
+
+	var word big.Int
+	word.Lsh(expTop2, 125)
+	word.Or(&word, new(big.Int).Lsh(coeffTop3, 122))
+	word.Or(&word, new(big.Int).Lsh(expLow12, 110))
+	word.Or(&word, coeffLow110)
+	if neg {
+		word.SetBit(&word, 127, 1)
+	}
+
+	var buf [16]byte
+	word.FillBytes(buf[:])
+	return Decimal128{
+		h: beUint64(buf[0:8]),
+		l: beUint64(buf[8:16]),
+	}, nil
+}
+
+func beUint64(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func putBeUint64(b []byte, v uint64) {
+	for i := 7; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+}
+
+// String returns the canonical string representation of d, matching the
+// MongoDB Decimal128 to-string algorithm.
+func (d Decimal128) String() string {
+	top := (d.h >> 58) & 0x1F
+	neg := d.h&(1<<63) != 0
+	if top == 0x1F {
+		return "NaN"
+	}
+	if top == 0x1E {
+		if neg {
+			return "-Infinity"
+		}
+		return "Infinity"
+	}
+
+	exp, coeff := d.decompose()
+	digits := coeff.String()
+
+	sign := ""
+	if neg {
+		sign = "-"
+	}
+
+	adjExp := exp + len(digits) - 1
+	var out string
+	if exp <= 0 && adjExp >= -6 {
+		// Plain notation.
+		if exp == 0 {
+			out = digits
+		} else {
+			point := len(digits) + exp
+			if point <= 0 {
+				out = "0." + strings.Repeat("0", -point) + digits
+			} else {
+				out = digits[:point] + "." + digits[point:]
+			}
+		}
+	} else {
+		// Scientific notation.
+		if len(digits) == 1 {
+			out = digits
+		} else {
+			out = digits[:1] + "." + digits[1:]
+		}
+		out += fmt.Sprintf("E%+d", adjExp)
+	}
+	return sign + out
+}
+
+// decompose extracts the unbiased exponent and unsigned coefficient from
+// d's bit layout, undoing newDecimal128.
+func (d Decimal128) decompose() (exp int, coeff *big.Int) {
+	var buf [16]byte
+	putBeUint64(buf[0:8], d.h)
+	putBeUint64(buf[8:16], d.l)
+	word := new(big.Int).SetBytes(buf[:])
+	word.SetBit(word, 127, 0) // clear sign
+
+	// The 5-bit combination field and 12-bit exponent continuation field
+	// sit between bits 122-126 and 110-121 respectively, ahead of the
+	// 110-bit coefficient continuation field in bits 0-109 — mirroring
+	// the interleaved layout newDecimal128 packs.
+	comb := new(big.Int).Rsh(word, 122)
+	comb.And(comb, big.NewInt(0x1F))
+	expLow12 := new(big.Int).Rsh(word, 110)
+	expLow12.And(expLow12, big.NewInt(0xFFF))
+	coeffLow110 := new(big.Int).And(word, dec128Low110Mask)
+
+	var biased, sig big.Int
+	if comb.Int64()>>3 == 3 {
+		// Case 2 layout: the combination field's top two bits are 11,
+		// so its next two bits are the exponent's MSBs and its last bit
+		// is the coefficient's explicit bit, following an implicit 100
+		// prefix (the coefficient's top digit is always 8 or 9 here).
+		biased.Lsh(new(big.Int).And(new(big.Int).Rsh(comb, 1), big.NewInt(0x3)), 12)
+		biased.Or(&biased, expLow12)
+		sig.SetInt64(0x8 | comb.Int64()&0x1)
+		sig.Lsh(&sig, 110)
+		sig.Or(&sig, coeffLow110)
+	} else {
+		// Case 1 layout: the combination field's 5 bits are the
+		// exponent's 2 MSBs followed by the coefficient's 3 MSBs.
+		biased.Lsh(new(big.Int).Rsh(comb, 3), 12)
+		biased.Or(&biased, expLow12)
+		sig.And(comb, big.NewInt(0x7))
+		sig.Lsh(&sig, 110)
+		sig.Or(&sig, coeffLow110)
+	}
+	return int(biased.Int64()) - dec128ExponentBias, &sig
+}
+
+// GetBSON implements the Getter interface, encoding d as a raw BSON
+// decimal128 element (kind 0x13). The wire format is little-endian.
+func (d Decimal128) GetBSON() (interface{}, error) {
+	var data [16]byte
+	putBeUint64(data[0:8], d.l)
+	putBeUint64(data[8:16], d.h)
+	reverse(data[0:8])
+	reverse(data[8:16])
+	return Raw{Kind: 0x13, Data: data[:]}, nil
+}
+
+// SetBSON implements the Setter interface, decoding a raw BSON
+// decimal128 element (kind 0x13) into d.
+func (d *Decimal128) SetBSON(raw Raw) error {
+	if raw.Kind != 0x13 {
+		return &TypeError{decimal128Type, raw.Kind}
+	}
+	if len(raw.Data) != 16 {
+		return errors.New("bson: invalid decimal128 data length")
+	}
+	low := append([]byte(nil), raw.Data[0:8]...)
+	high := append([]byte(nil), raw.Data[8:16]...)
+	reverse(low)
+	reverse(high)
+	d.l = beUint64(low)
+	d.h = beUint64(high)
+	return nil
+}
+
+func reverse(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
+
+// MarshalJSON renders d using the canonical MongoDB Extended JSON
+// $numberDecimal form.
+func (d Decimal128) MarshalJSON() ([]byte, error) {
+	return []byte(`{"$numberDecimal":"` + d.String() + `"}`), nil
+}
+
+// UnmarshalJSON accepts either the $numberDecimal wrapper or a bare JSON
+// string and parses it into d.
+func (d *Decimal128) UnmarshalJSON(data []byte) error {
+	s := strings.TrimSpace(string(data))
+	if strings.HasPrefix(s, "{") {
+		var wrapper struct {
+			NumberDecimal string `json:"$numberDecimal"`
+		}
+		if err := json.Unmarshal(data, &wrapper); err != nil {
+			return err
+		}
+		s = wrapper.NumberDecimal
+	} else {
+		s = strings.Trim(s, `"`)
+	}
+	parsed, err := ParseDecimal128(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
@@ -0,0 +1,181 @@
+package bson
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestDecimal128ParseString(t *testing.T) {
+	cases := []struct {
+		in, out string
+	}{
+		{"0", "0"},
+		{"-0", "-0"},
+		{"1", "1"},
+		{"-1", "-1"},
+		{"1.5", "1.5"},
+		{"3.14159", "3.14159"},
+		{"123456789012345678901234567890123456", "1.234567890123456789012345678901235E+35"},
+		{"0.000001234", "0.000001234"},
+		{"1E+6111", "1E+6111"},
+		{"NaN", "NaN"},
+		{"Inf", "Infinity"},
+		{"-Inf", "-Infinity"},
+		{"Infinity", "Infinity"},
+	}
+	for _, c := range cases {
+		d, err := ParseDecimal128(c.in)
+		if err != nil {
+			t.Errorf("ParseDecimal128(%q) returned error: %v", c.in, err)
+			continue
+		}
+		if got := d.String(); got != c.out {
+			t.Errorf("ParseDecimal128(%q).String() = %q, want %q", c.in, got, c.out)
+		}
+	}
+}
+
+func TestDecimal128ParseInvalid(t *testing.T) {
+	cases := []string{"", "+", "-", ".", "1.2.3", "1e", "abc"}
+	for _, in := range cases {
+		if _, err := ParseDecimal128(in); err == nil {
+			t.Errorf("ParseDecimal128(%q) expected error, got none", in)
+		}
+	}
+}
+
+// TestDecimal128RoundHalfEven exercises the round-half-even rule used when
+// a string carries more than 34 significant digits.
+func TestDecimal128RoundHalfEven(t *testing.T) {
+	cases := map[string]string{
+		// 37 and 36 significant digits, each rounded to 34; the dropped
+		// digits are above half, so the last kept digit rounds up.
+		"0.1234567890123456789012345678901234567": "0.1234567890123456789012345678901235",
+		"123456789012345678901234567890123456":    "1.234567890123456789012345678901235E+35",
+	}
+	for in, want := range cases {
+		d, err := ParseDecimal128(in)
+		if err != nil {
+			t.Fatalf("ParseDecimal128(%q): %v", in, err)
+		}
+		if got := d.String(); got != want {
+			t.Errorf("ParseDecimal128(%q).String() = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// TestDecimal128RoundCarryOverflow covers the case where rounding up a
+// string of 35 nines carries the coefficient to 10^34, which itself needs
+// 35 digits and must be renormalized down to the 34-digit canonical form.
+func TestDecimal128RoundCarryOverflow(t *testing.T) {
+	in := "99999999999999999999999999999999999" // 35 nines
+	want := "1.000000000000000000000000000000000E+35"
+	d, err := ParseDecimal128(in)
+	if err != nil {
+		t.Fatalf("ParseDecimal128(%q): %v", in, err)
+	}
+	if got := d.String(); got != want {
+		t.Fatalf("ParseDecimal128(%q).String() = %q, want %q", in, got, want)
+	}
+}
+
+func TestDecimal128JSON(t *testing.T) {
+	d, err := ParseDecimal128("1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := d.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"$numberDecimal":"1.5"}`
+	if string(data) != want {
+		t.Fatalf("MarshalJSON = %s, want %s", data, want)
+	}
+	var d2 Decimal128
+	if err := d2.UnmarshalJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	if d2.String() != d.String() {
+		t.Fatalf("round-trip mismatch: got %s, want %s", d2.String(), d.String())
+	}
+}
+
+// TestDecimal128ReferenceVectors checks GetBSON's output against the wire
+// bytes derived directly from the IEEE754-2008/BSON decimal128 bit layout
+// (sign | 5-bit combination | 12-bit exponent continuation | 110-bit
+// coefficient continuation, combination holding the exponent's top 2 bits
+// interleaved with the coefficient's top 3 bits), rather than round-tripping
+// through this package's own encode/decode pair. Catches any regression to
+// the contiguous-field packing bug where the combination field wasn't
+// interleaved with the continuation fields.
+func TestDecimal128ReferenceVectors(t *testing.T) {
+	cases := []struct {
+		in  string
+		hex string
+	}{
+		// coeff=1, exp=0: combination = exponent top 2 bits (01) followed
+		// by coefficient top 3 bits (000) = 01000, not the 14-bit biased
+		// exponent (01100000100000) laid out contiguously.
+		{"1", "01000000000000000000000000000822"},
+		{"0", "00000000000000000000000000000822"},
+		{"-1", "010000000000000000000000000008a2"},
+		// coeff=100, exp=-2 ("1.00"): exercises a non-zero exponent
+		// continuation field alongside a non-trivial coefficient.
+		{"1.00", "64000000000000000000000000800722"},
+	}
+	for _, c := range cases {
+		d, err := ParseDecimal128(c.in)
+		if err != nil {
+			t.Fatalf("ParseDecimal128(%q): %v", c.in, err)
+		}
+		raw, err := d.GetBSON()
+		if err != nil {
+			t.Fatalf("GetBSON(%q): %v", c.in, err)
+		}
+		rawVal, ok := raw.(Raw)
+		if !ok {
+			t.Fatalf("GetBSON(%q) returned %T, want Raw", c.in, raw)
+		}
+		if got := hex.EncodeToString(rawVal.Data); got != c.hex {
+			t.Errorf("GetBSON(%q) = %s, want %s", c.in, got, c.hex)
+		}
+
+		// And the inverse: decoding the reference bytes must reproduce
+		// the original decimal string, not just whatever this package's
+		// own encoder happened to produce.
+		data, err := hex.DecodeString(c.hex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var d2 Decimal128
+		if err := d2.SetBSON(Raw{Kind: 0x13, Data: data}); err != nil {
+			t.Fatalf("SetBSON(%q): %v", c.hex, err)
+		}
+		if got := d2.String(); got != d.String() {
+			t.Errorf("SetBSON(%s).String() = %q, want %q", c.hex, got, d.String())
+		}
+	}
+}
+
+func TestDecimal128BSONRoundTrip(t *testing.T) {
+	d, err := ParseDecimal128("-42.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := d.GetBSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawVal, ok := raw.(Raw)
+	if !ok {
+		t.Fatalf("GetBSON returned %T, want Raw", raw)
+	}
+	var d2 Decimal128
+	if err := d2.SetBSON(rawVal); err != nil {
+		t.Fatal(err)
+	}
+	if d2.String() != d.String() {
+		t.Fatalf("round-trip mismatch: got %s, want %s", d2.String(), d.String())
+	}
+}
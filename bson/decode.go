@@ -0,0 +1,668 @@
+// BSON library for Go
+//
+// Copyright (c) 2010-2012 - Gustavo Niemeyer <gustavo@niemeyer.net>
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package bson
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var (
+	typeM     = reflect.TypeOf(M{})
+	typeIface = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+var setterStyles map[reflect.Type]int
+var setterIface reflect.Type
+var setterMutex sync.RWMutex
+
+func init() {
+	var iface Setter
+	setterIface = reflect.TypeOf(&iface).Elem()
+	setterStyles = make(map[reflect.Type]int)
+}
+
+func setterStyle(outt reflect.Type) int {
+	setterMutex.RLock()
+	style := setterStyles[outt]
+	setterMutex.RUnlock()
+	if style != getterUnknown {
+		return style
+	}
+
+	setterMutex.Lock()
+	defer setterMutex.Unlock()
+	if reflect.PtrTo(outt).Implements(setterIface) {
+		style = getterAddr
+	} else if outt.Implements(setterIface) {
+		style = getterTypeVal
+	} else {
+		style = getterNone
+	}
+	setterStyles[outt] = style
+	return style
+}
+
+// getSetter returns out (or its address) as a Setter if either implements
+// the interface, allocating a pointer target if necessary. It mirrors
+// getGetter in encode.go.
+func getSetter(outt reflect.Type, out reflect.Value) Setter {
+	style := setterStyle(outt)
+	if style == getterNone {
+		return nil
+	}
+	if style == getterAddr {
+		if !out.CanAddr() {
+			return nil
+		}
+		return out.Addr().Interface().(Setter)
+	}
+	if out.Kind() == reflect.Ptr && out.IsNil() {
+		out.Set(reflect.New(out.Type().Elem()))
+	}
+	return out.Interface().(Setter)
+}
+
+// --------------------------------------------------------------------------
+// Unmarshaling of documents.
+
+// decoder reads successive BSON documents and elements from in, starting
+// at byte offset i. docType, when set, is the concrete type readDocTo
+// should allocate when asked to decode a document into a bare
+// interface{}; Unmarshal and Raw.Unmarshal leave it nil, in which case M
+// is used, matching the map type Marshal itself would produce for a
+// corresponding document built from Go values.
+type decoder struct {
+	in      []byte
+	i       int
+	docType reflect.Type
+}
+
+func (d *decoder) readDocTo(out reflect.Value) {
+	for {
+		outt := out.Type()
+		if outt == typeRaw {
+			out.Set(reflect.ValueOf(d.readRaw()))
+			return
+		}
+		if setter := getSetter(outt, out); setter != nil {
+			raw := d.readRaw()
+			if err := setter.SetBSON(raw); err != nil {
+				panic(err)
+			}
+			return
+		}
+		if outt.Kind() == reflect.Ptr {
+			if out.IsNil() {
+				out.Set(reflect.New(outt.Elem()))
+			}
+			out = out.Elem()
+			continue
+		}
+		break
+	}
+
+	outt := out.Type()
+
+	if outt == typeIface {
+		docType := d.docType
+		if docType == nil {
+			docType = typeM
+		}
+		newv := reflect.New(docType).Elem()
+		d.readDocTo(newv)
+		out.Set(newv)
+		return
+	}
+
+	switch outt.Kind() {
+	case reflect.Map:
+		d.readMapDoc(out)
+	case reflect.Struct:
+		d.readStructDoc(out)
+	case reflect.Slice, reflect.Array:
+		d.readSliceDoc(out)
+	default:
+		panic("Unsupported document target: " + outt.String())
+	}
+}
+
+func (d *decoder) readDocLen() int {
+	if d.i+4 > len(d.in) {
+		corrupted()
+	}
+	n := int(int32(uint32(d.in[d.i]) | uint32(d.in[d.i+1])<<8 | uint32(d.in[d.i+2])<<16 | uint32(d.in[d.i+3])<<24))
+	if n < 5 {
+		corrupted()
+	}
+	return n
+}
+
+func (d *decoder) readRaw() Raw {
+	start := d.i
+	n := d.readDocLen()
+	end := start + n
+	if end > len(d.in) {
+		corrupted()
+	}
+	d.i = end
+	return Raw{Kind: 0x03, Data: d.in[start:end]}
+}
+
+func (d *decoder) readMapDoc(out reflect.Value) {
+	start := d.i
+	n := d.readDocLen()
+	end := start + n
+	if end > len(d.in) {
+		corrupted()
+	}
+	d.i = start + 4
+
+	outt := out.Type()
+	if out.IsNil() {
+		out.Set(reflect.MakeMap(outt))
+	}
+	keyType := outt.Key()
+	elemType := outt.Elem()
+
+	for d.i < end-1 {
+		kind := d.readByte()
+		name := d.readCStr()
+		v := reflect.New(elemType).Elem()
+		d.readElemTo(v, kind)
+		k := reflect.New(keyType).Elem()
+		k.SetString(name)
+		out.SetMapIndex(k, v)
+	}
+	d.i = end
+}
+
+func (d *decoder) readStructDoc(out reflect.Value) {
+	start := d.i
+	n := d.readDocLen()
+	end := start + n
+	if end > len(d.in) {
+		corrupted()
+	}
+	d.i = start + 4
+
+	sinfo, err := getStructInfo(out.Type())
+	if err != nil {
+		panic(err)
+	}
+
+	for d.i < end-1 {
+		kind := d.readByte()
+		name := d.readCStr()
+		if info, ok := sinfo.FieldsMap[name]; ok {
+			var field reflect.Value
+			if info.Inline == nil {
+				field = out.Field(info.Num)
+			} else {
+				field = out.FieldByIndex(info.Inline)
+			}
+			d.readElemTo(field, kind)
+		} else {
+			d.dropElem(kind)
+		}
+	}
+	d.i = end
+}
+
+func (d *decoder) readSliceDoc(out reflect.Value) {
+	start := d.i
+	n := d.readDocLen()
+	end := start + n
+	if end > len(d.in) {
+		corrupted()
+	}
+	d.i = start + 4
+
+	outt := out.Type()
+	et := outt.Elem()
+
+	switch et {
+	case typeDocElem:
+		var elems []DocElem
+		for d.i < end-1 {
+			kind := d.readByte()
+			name := d.readCStr()
+			v := reflect.New(typeIface).Elem()
+			d.readElemTo(v, kind)
+			elems = append(elems, DocElem{Name: name, Value: v.Interface()})
+		}
+		d.i = end
+		out.Set(reflect.ValueOf(elems).Convert(outt))
+		return
+	case typeRawDocElem:
+		var elems []RawDocElem
+		for d.i < end-1 {
+			kind := d.readByte()
+			name := d.readCStr()
+			elems = append(elems, RawDocElem{Name: name, Value: d.readRawValue(kind)})
+		}
+		d.i = end
+		out.Set(reflect.ValueOf(elems).Convert(outt))
+		return
+	}
+
+	var elems []reflect.Value
+	for d.i < end-1 {
+		kind := d.readByte()
+		d.readCStr() // the array index, used only positionally
+		v := reflect.New(et).Elem()
+		d.readElemTo(v, kind)
+		elems = append(elems, v)
+	}
+	d.i = end
+
+	if outt.Kind() == reflect.Array {
+		for i, v := range elems {
+			if i >= out.Len() {
+				break
+			}
+			out.Index(i).Set(v)
+		}
+		return
+	}
+	slice := reflect.MakeSlice(outt, len(elems), len(elems))
+	for i, v := range elems {
+		slice.Index(i).Set(v)
+	}
+	out.Set(slice)
+}
+
+func (d *decoder) readRawValue(kind byte) Raw {
+	start := d.i
+	n, err := rawValueLen(kind, d.in[d.i:])
+	if err != nil {
+		panic(err)
+	}
+	d.i += n
+	return Raw{Kind: kind, Data: d.in[start:d.i]}
+}
+
+func (d *decoder) dropElem(kind byte) {
+	n, err := rawValueLen(kind, d.in[d.i:])
+	if err != nil {
+		panic(err)
+	}
+	d.i += n
+}
+
+// --------------------------------------------------------------------------
+// Unmarshaling of individual elements.
+
+// readElemTo decodes the element of the given kind at d's current
+// position into out, advancing past it, and reports whether out's type
+// was compatible with kind. An incompatible combination is not an error:
+// it's how Raw.Unmarshal and struct/map decoding both signal "skip this
+// value" to their callers, mirroring Marshal's reflection-driven style
+// rather than hand-written per-kind decoders.
+func (d *decoder) readElemTo(out reflect.Value, kind byte) (good bool) {
+	if setter := getSetter(out.Type(), out); setter != nil {
+		raw := d.readRawValue(kind)
+		if err := setter.SetBSON(raw); err != nil {
+			if _, ok := err.(*TypeError); ok {
+				return false
+			}
+			panic(err)
+		}
+		return true
+	}
+
+	outt := out.Type()
+	for outt.Kind() == reflect.Ptr {
+		if kind == 0x0A {
+			d.dropElem(kind)
+			out.Set(reflect.Zero(outt))
+			return true
+		}
+		if out.IsNil() {
+			out.Set(reflect.New(outt.Elem()))
+		}
+		out = out.Elem()
+		outt = out.Type()
+	}
+
+	if outt == typeRaw {
+		out.Set(reflect.ValueOf(d.readRawValue(kind)))
+		return true
+	}
+
+	switch kind {
+	case 0x01: // double
+		return setNumber(out, d.readFloat64())
+	case 0x02, 0x0D, 0x0E: // string, javascript, symbol
+		s := d.readStr()
+		switch {
+		case outt.Kind() == reflect.String:
+			out.SetString(s)
+		case outt == typeIface:
+			if kind == 0x0E {
+				out.Set(reflect.ValueOf(Symbol(s)))
+			} else {
+				out.Set(reflect.ValueOf(s))
+			}
+		default:
+			return false
+		}
+		return true
+	case 0x03, 0x04: // document, array
+		d.readDocTo(out)
+		return true
+	case 0x05: // binary
+		b := d.readBinary()
+		switch {
+		case outt == typeBinary:
+			out.Set(reflect.ValueOf(b))
+		case outt.Kind() == reflect.Slice && outt.Elem().Kind() == reflect.Uint8:
+			if b.Kind != 0x00 {
+				return false
+			}
+			out.SetBytes(b.Data)
+		case outt == typeIface:
+			if b.Kind == 0x00 {
+				out.Set(reflect.ValueOf(b.Data))
+			} else {
+				out.Set(reflect.ValueOf(b))
+			}
+		default:
+			return false
+		}
+		return true
+	case 0x06: // undefined
+		if outt == typeIface {
+			out.Set(reflect.ValueOf(Undefined))
+		} else {
+			out.Set(reflect.Zero(outt))
+		}
+		return true
+	case 0x07: // objectid
+		id := ObjectId(append([]byte(nil), d.readBytes(12)...))
+		switch outt {
+		case typeObjectId, typeIface:
+			out.Set(reflect.ValueOf(id))
+		default:
+			return false
+		}
+		return true
+	case 0x08: // bool
+		b := d.readByte() != 0
+		switch outt.Kind() {
+		case reflect.Bool:
+			out.SetBool(b)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if b {
+				out.SetInt(1)
+			} else {
+				out.SetInt(0)
+			}
+		case reflect.Interface:
+			out.Set(reflect.ValueOf(b))
+		default:
+			return false
+		}
+		return true
+	case 0x09: // datetime
+		ms := d.readInt64()
+		t := time.Unix(ms/1000, (ms%1000)*1e6).UTC()
+		switch outt {
+		case typeTime, typeIface:
+			out.Set(reflect.ValueOf(t))
+		default:
+			return false
+		}
+		return true
+	case 0x0A: // null
+		out.Set(reflect.Zero(outt))
+		return true
+	case 0x0B: // regex
+		re := RegEx{Pattern: d.readCStr(), Options: d.readCStr()}
+		switch outt {
+		case typeRegEx, typeIface:
+			out.Set(reflect.ValueOf(re))
+		default:
+			return false
+		}
+		return true
+	case 0x0C: // dbpointer
+		ref := d.readStr()
+		id := ObjectId(append([]byte(nil), d.readBytes(12)...))
+		if outt != typeIface {
+			return false
+		}
+		out.Set(reflect.ValueOf(M{"$ref": ref, "$id": id}))
+		return true
+	case 0x0F: // javascript with scope
+		code, scope := d.readCodeWithScope()
+		js := JavaScript{Code: code, Scope: scope}
+		switch outt {
+		case typeJavaScript, typeIface:
+			out.Set(reflect.ValueOf(js))
+		default:
+			return false
+		}
+		return true
+	case 0x10: // int32
+		n := d.readInt32()
+		if outt == typeIface {
+			out.Set(reflect.ValueOf(n))
+			return true
+		}
+		return setNumber(out, int64(n))
+	case 0x11: // timestamp
+		v := d.readInt64()
+		switch outt {
+		case typeMongoTimestamp:
+			out.SetInt(v)
+		case typeIface:
+			out.Set(reflect.ValueOf(MongoTimestamp(v)))
+		default:
+			return false
+		}
+		return true
+	case 0x12: // int64
+		return setNumber(out, d.readInt64())
+	case 0x13: // decimal128
+		data := d.readBytes(16)
+		low := append([]byte(nil), data[0:8]...)
+		high := append([]byte(nil), data[8:16]...)
+		reverse(low)
+		reverse(high)
+		dec := Decimal128{l: beUint64(low), h: beUint64(high)}
+		switch outt {
+		case decimal128Type, typeIface:
+			out.Set(reflect.ValueOf(dec))
+		default:
+			return false
+		}
+		return true
+	case 0xFF: // minkey
+		switch outt {
+		case typeOrderKey, typeIface:
+			out.Set(reflect.ValueOf(MinKey))
+		default:
+			return false
+		}
+		return true
+	case 0x7F: // maxkey
+		switch outt {
+		case typeOrderKey, typeIface:
+			out.Set(reflect.ValueOf(MaxKey))
+		default:
+			return false
+		}
+		return true
+	}
+	panic(fmt.Sprintf("bson: unknown element kind 0x%02x", kind))
+}
+
+// setNumber assigns a BSON numeric value to out, converting between Go's
+// numeric kinds the same way Unmarshal's documentation promises: an
+// integer target is set only if the value's integer part is preserved, a
+// bool target is set to true if the value isn't 0, and a bare interface{}
+// target keeps the value's native Go type. It returns false, leaving out
+// unchanged, for a value that doesn't fit.
+func setNumber(out reflect.Value, n interface{}) bool {
+	var f float64
+	var i int64
+	switch v := n.(type) {
+	case int64:
+		i, f = v, float64(v)
+	case float64:
+		i, f = int64(v), v
+	}
+	switch out.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if out.OverflowInt(i) || float64(i) != math.Trunc(f) {
+			return false
+		}
+		out.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i < 0 || out.OverflowUint(uint64(i)) || float64(i) != math.Trunc(f) {
+			return false
+		}
+		out.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		out.SetFloat(f)
+	case reflect.Bool:
+		out.SetBool(i != 0)
+	case reflect.Interface:
+		out.Set(reflect.ValueOf(n))
+	default:
+		return false
+	}
+	return true
+}
+
+// --------------------------------------------------------------------------
+// Parsing of base types.
+
+func corrupted() {
+	panic("Document is corrupted")
+}
+
+func (d *decoder) readByte() byte {
+	if d.i+1 > len(d.in) {
+		corrupted()
+	}
+	b := d.in[d.i]
+	d.i++
+	return b
+}
+
+func (d *decoder) readBytes(n int) []byte {
+	if n < 0 || d.i+n > len(d.in) {
+		corrupted()
+	}
+	b := d.in[d.i : d.i+n]
+	d.i += n
+	return b
+}
+
+func (d *decoder) readCStr() string {
+	i := d.i
+	for i < len(d.in) && d.in[i] != 0 {
+		i++
+	}
+	if i >= len(d.in) {
+		corrupted()
+	}
+	s := string(d.in[d.i:i])
+	d.i = i + 1
+	return s
+}
+
+func (d *decoder) readStr() string {
+	n := int(d.readUint32())
+	if n < 1 || d.i+n > len(d.in) {
+		corrupted()
+	}
+	s := string(d.in[d.i : d.i+n-1])
+	d.i += n
+	return s
+}
+
+func (d *decoder) readUint32() uint32 {
+	b := d.readBytes(4)
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+func (d *decoder) readInt32() int32 {
+	return int32(d.readUint32())
+}
+
+func (d *decoder) readUint64() uint64 {
+	b := d.readBytes(8)
+	var v uint64
+	for i := 7; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v
+}
+
+func (d *decoder) readInt64() int64 {
+	return int64(d.readUint64())
+}
+
+func (d *decoder) readFloat64() float64 {
+	return math.Float64frombits(d.readUint64())
+}
+
+func (d *decoder) readBinary() Binary {
+	n := int(d.readUint32())
+	if n < 0 {
+		corrupted()
+	}
+	subtype := d.readByte()
+	if subtype == 0x02 {
+		// Obsolete binary subtype with a redundant inner length prefix.
+		if n < 4 {
+			corrupted()
+		}
+		inner := int(d.readUint32())
+		if inner != n-4 {
+			corrupted()
+		}
+		data := d.readBytes(inner)
+		return Binary{Kind: subtype, Data: append([]byte(nil), data...)}
+	}
+	data := d.readBytes(n)
+	return Binary{Kind: subtype, Data: append([]byte(nil), data...)}
+}
+
+func (d *decoder) readCodeWithScope() (code string, scope interface{}) {
+	d.readUint32() // total code-with-scope length; not checked here, the code string and scope are bounds-checked as they're read
+	code = d.readStr()
+	scopeV := reflect.New(typeM).Elem()
+	d.readDocTo(scopeV)
+	return code, scopeV.Interface()
+}
@@ -0,0 +1,633 @@
+package bson
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// MarshalExtJSON marshals v, which follows the same rules as Marshal, into
+// MongoDB Extended JSON (https://www.mongodb.com/docs/manual/reference/mongodb-extended-json/).
+//
+// When canonical is true, every non-native-JSON BSON type, including
+// numeric types, is wrapped in its type-preserving form (for instance
+// "$numberLong"). When canonical is false ("relaxed" mode), numeric types
+// that can be represented losslessly as a bare JSON number are emitted as
+// such, and only types with no JSON equivalent (ObjectId, Binary, and so
+// on) are wrapped.
+//
+// escapeHTML controls whether '<', '>' and '&' are escaped in the output,
+// mirroring the json.Encoder.SetEscapeHTML option.
+//
+// DBPointer values (BSON kind 0x0C) are marshaled to their "$dbPointer"
+// wrapper form, but that form has no native Go representation and cannot
+// be read back by UnmarshalExtJSON; round-tripping a document containing
+// one will fail on the way back in.
+func MarshalExtJSON(v interface{}, canonical, escapeHTML bool) ([]byte, error) {
+	data, err := Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := writeExtJSONDoc(&buf, data, canonical, escapeHTML); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalExtJSON parses Extended JSON data and stores the result in out,
+// which follows the same rules as the out parameter of Unmarshal. Both
+// canonical and relaxed v2 forms are accepted regardless of the canonical
+// argument; it is kept for symmetry with MarshalExtJSON and to allow
+// stricter validation in the future. The legacy v1 forms still produced by
+// some tooling ({"$regex":..,"$options":..}, {"$binary":"<base64>",
+// "$type":"<hex>"} and a bare-number $date) are also accepted on decode,
+// though MarshalExtJSON only ever produces the v2 shapes.
+func UnmarshalExtJSON(data []byte, canonical bool, out interface{}) (err error) {
+	defer handleErr(&err)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return err
+	}
+	converted, err := convertExtJSON(tree)
+	if err != nil {
+		return err
+	}
+	bsonData, err := Marshal(converted)
+	if err != nil {
+		return err
+	}
+	return Unmarshal(bsonData, out)
+}
+
+// RawExtJSON represents a chunk of unprocessed Extended JSON, symmetrical
+// to Raw for BSON. It is useful for transcoding partial documents between
+// the two formats without fully decoding them.
+type RawExtJSON []byte
+
+// MarshalJSON returns r's contents unmodified.
+func (r RawExtJSON) MarshalJSON() ([]byte, error) {
+	if r == nil {
+		return []byte("null"), nil
+	}
+	return []byte(r), nil
+}
+
+// UnmarshalJSON stores a copy of data in r.
+func (r *RawExtJSON) UnmarshalJSON(data []byte) error {
+	*r = append((*r)[:0], data...)
+	return nil
+}
+
+// GetBSON implements the Getter interface, converting the Extended JSON
+// held by r into the equivalent BSON value.
+func (r RawExtJSON) GetBSON() (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(r))
+	dec.UseNumber()
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return convertExtJSON(tree)
+}
+
+// SetBSON implements the Setter interface, converting raw into its
+// canonical Extended JSON form and storing it in r.
+func (r *RawExtJSON) SetBSON(raw Raw) error {
+	var buf bytes.Buffer
+	if err := writeExtJSONValue(&buf, raw.Kind, raw.Data, true, false); err != nil {
+		return err
+	}
+	*r = append((*r)[:0], buf.Bytes()...)
+	return nil
+}
+
+// --------------------------------------------------------------------------
+// BSON -> Extended JSON
+
+func writeExtJSONDoc(buf *bytes.Buffer, data []byte, canonical, escapeHTML bool) error {
+	elems, err := parseRawDoc(data)
+	if err != nil {
+		return err
+	}
+	buf.WriteByte('{')
+	for i, e := range elems {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeJSONString(buf, e.Name, escapeHTML)
+		buf.WriteByte(':')
+		if err := writeExtJSONValue(buf, e.Kind, e.Data, canonical, escapeHTML); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func writeExtJSONArray(buf *bytes.Buffer, data []byte, canonical, escapeHTML bool) error {
+	elems, err := parseRawDoc(data)
+	if err != nil {
+		return err
+	}
+	buf.WriteByte('[')
+	for i, e := range elems {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := writeExtJSONValue(buf, e.Kind, e.Data, canonical, escapeHTML); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string, escapeHTML bool) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(escapeHTML)
+	// Encode never fails for a string.
+	_ = enc.Encode(s)
+	buf.Write(bytes.TrimRight(b.Bytes(), "\n"))
+}
+
+func writeExtJSONValue(buf *bytes.Buffer, kind byte, data []byte, canonical, escapeHTML bool) error {
+	switch kind {
+	case 0x01: // double
+		f := math.Float64frombits(binary.LittleEndian.Uint64(data))
+		writeExtJSONDouble(buf, f, canonical)
+	case 0x02: // string
+		s := string(data[4 : len(data)-1])
+		writeJSONString(buf, s, escapeHTML)
+	case 0x03: // document
+		return writeExtJSONDoc(buf, data, canonical, escapeHTML)
+	case 0x04: // array
+		return writeExtJSONArray(buf, data, canonical, escapeHTML)
+	case 0x05: // binary
+		n := int(int32(binary.LittleEndian.Uint32(data)))
+		subtype := data[4]
+		payload := data[5 : 5+n]
+		fmt.Fprintf(buf, `{"$binary":{"base64":"%s","subType":"%02x"}}`, base64.StdEncoding.EncodeToString(payload), subtype)
+	case 0x06: // undefined
+		buf.WriteString(`{"$undefined":true}`)
+	case 0x07: // objectid
+		fmt.Fprintf(buf, `{"$oid":"%s"}`, hex.EncodeToString(data[:12]))
+	case 0x08: // bool
+		if data[0] != 0 {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case 0x09: // datetime
+		ms := int64(binary.LittleEndian.Uint64(data))
+		writeExtJSONDate(buf, ms, canonical)
+	case 0x0A: // null
+		buf.WriteString("null")
+	case 0x0B: // regex
+		i := indexNUL(data)
+		pattern := string(data[:i])
+		options := string(data[i+1 : len(data)-1])
+		buf.WriteString(`{"$regularExpression":{"pattern":`)
+		writeJSONString(buf, pattern, escapeHTML)
+		buf.WriteString(`,"options":`)
+		writeJSONString(buf, options, escapeHTML)
+		buf.WriteString("}}")
+	case 0x0C: // dbpointer
+		if len(data) < 4 {
+			return fmt.Errorf("bson: truncated dbpointer")
+		}
+		n := int(int32(binary.LittleEndian.Uint32(data)))
+		if n < 1 || 4+n+12 > len(data) {
+			return fmt.Errorf("bson: invalid dbpointer length")
+		}
+		ref := string(data[4 : 4+n-1])
+		oid := data[4+n : 4+n+12]
+		buf.WriteString(`{"$dbPointer":{"$ref":`)
+		writeJSONString(buf, ref, escapeHTML)
+		fmt.Fprintf(buf, `,"$id":{"$oid":"%s"}}}`, hex.EncodeToString(oid))
+	case 0x0D: // javascript without scope
+		code := string(data[4 : len(data)-1])
+		buf.WriteString(`{"$code":`)
+		writeJSONString(buf, code, escapeHTML)
+		buf.WriteByte('}')
+	case 0x0E: // symbol
+		s := string(data[4 : len(data)-1])
+		buf.WriteString(`{"$symbol":`)
+		writeJSONString(buf, s, escapeHTML)
+		buf.WriteByte('}')
+	case 0x0F: // javascript with scope
+		if len(data) < 8 {
+			return fmt.Errorf("bson: truncated code-with-scope")
+		}
+		codeLen := int(int32(binary.LittleEndian.Uint32(data[4:])))
+		if codeLen < 1 || 8+codeLen > len(data) {
+			return fmt.Errorf("bson: invalid code-with-scope code length")
+		}
+		code := string(data[8 : 8+codeLen-1])
+		scope := data[8+codeLen:]
+		buf.WriteString(`{"$code":`)
+		writeJSONString(buf, code, escapeHTML)
+		buf.WriteString(`,"$scope":`)
+		if err := writeExtJSONDoc(buf, scope, canonical, escapeHTML); err != nil {
+			return err
+		}
+		buf.WriteByte('}')
+	case 0x10: // int32
+		n := int32(binary.LittleEndian.Uint32(data))
+		if canonical {
+			fmt.Fprintf(buf, `{"$numberInt":"%d"}`, n)
+		} else {
+			fmt.Fprintf(buf, "%d", n)
+		}
+	case 0x11: // timestamp
+		v := binary.LittleEndian.Uint64(data)
+		inc := uint32(v)
+		t := uint32(v >> 32)
+		fmt.Fprintf(buf, `{"$timestamp":{"t":%d,"i":%d}}`, t, inc)
+	case 0x12: // int64
+		n := int64(binary.LittleEndian.Uint64(data))
+		if !canonical && n >= -(1<<53) && n <= 1<<53 {
+			fmt.Fprintf(buf, "%d", n)
+		} else {
+			fmt.Fprintf(buf, `{"$numberLong":"%d"}`, n)
+		}
+	case 0x13: // decimal128
+		var d Decimal128
+		if err := d.SetBSON(Raw{Kind: 0x13, Data: data}); err != nil {
+			return err
+		}
+		out, _ := d.MarshalJSON()
+		buf.Write(out)
+	case 0xFF: // minkey
+		buf.WriteString(`{"$minKey":1}`)
+	case 0x7F: // maxkey
+		buf.WriteString(`{"$maxKey":1}`)
+	default:
+		return fmt.Errorf("bson: cannot convert kind 0x%02x to Extended JSON", kind)
+	}
+	return nil
+}
+
+func writeExtJSONDouble(buf *bytes.Buffer, f float64, canonical bool) {
+	// Special values have no JSON number equivalent, so they are always
+	// wrapped regardless of canonical mode.
+	switch {
+	case math.IsNaN(f):
+		buf.WriteString(`{"$numberDouble":"NaN"}`)
+		return
+	case math.IsInf(f, 1):
+		buf.WriteString(`{"$numberDouble":"Infinity"}`)
+		return
+	case math.IsInf(f, -1):
+		buf.WriteString(`{"$numberDouble":"-Infinity"}`)
+		return
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if canonical {
+		fmt.Fprintf(buf, `{"$numberDouble":"%s"}`, s)
+		return
+	}
+	fmt.Fprintf(buf, "%s", s)
+}
+
+func writeExtJSONDate(buf *bytes.Buffer, ms int64, canonical bool) {
+	if !canonical {
+		t := time.Unix(ms/1000, (ms%1000)*1e6).UTC()
+		if t.Year() >= 0 && t.Year() <= 9999 {
+			fmt.Fprintf(buf, `{"$date":"%s"}`, t.Format("2006-01-02T15:04:05.000Z"))
+			return
+		}
+	}
+	fmt.Fprintf(buf, `{"$date":{"$numberLong":"%d"}}`, ms)
+}
+
+// --------------------------------------------------------------------------
+// Extended JSON -> BSON
+
+func convertExtJSON(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return convertExtJSONObject(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			c, err := convertExtJSON(e)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = c
+		}
+		return out, nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			// Prefer int32 when the value fits, so that relaxed-mode
+			// decoding of a bare number into a generic M/interface{}
+			// reproduces the original BSON kind (0x10) on a subsequent
+			// Marshal, rather than always widening to int64 (0x12).
+			if n >= math.MinInt32 && n <= math.MaxInt32 {
+				return int32(n), nil
+			}
+			return n, nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	default:
+		return v, nil
+	}
+}
+
+func convertExtJSONObject(m map[string]interface{}) (interface{}, error) {
+	// Legacy (v1) Extended JSON spells $regularExpression and $binary as
+	// two sibling keys instead of nesting their fields in a single
+	// wrapper object. Recognize those shapes before the v2 single-key
+	// switch below, so documents produced by older tooling still decode.
+	if len(m) == 2 && has(m, "$regex") && has(m, "$options") {
+		return convertExtJSONRegexLegacy(m)
+	}
+	if len(m) == 2 && has(m, "$binary") && has(m, "$type") {
+		if _, ok := m["$binary"].(string); ok {
+			return convertExtJSONBinaryLegacy(m)
+		}
+	}
+
+	if len(m) == 1 || (len(m) == 2 && has(m, "$code") && has(m, "$scope")) {
+		for key := range m {
+			switch key {
+			case "$oid":
+				s, err := extJSONString(m, "$oid")
+				if err != nil {
+					return nil, err
+				}
+				return extJSONObjectId(s)
+			case "$numberInt":
+				s, err := extJSONString(m, "$numberInt")
+				if err != nil {
+					return nil, err
+				}
+				n, err := strconv.ParseInt(s, 10, 32)
+				if err != nil {
+					return nil, err
+				}
+				return int32(n), nil
+			case "$numberLong":
+				s, err := extJSONString(m, "$numberLong")
+				if err != nil {
+					return nil, err
+				}
+				n, err := strconv.ParseInt(s, 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				return n, nil
+			case "$numberDouble":
+				s, err := extJSONString(m, "$numberDouble")
+				if err != nil {
+					return nil, err
+				}
+				return parseExtJSONDouble(s)
+			case "$numberDecimal":
+				s, err := extJSONString(m, "$numberDecimal")
+				if err != nil {
+					return nil, err
+				}
+				return ParseDecimal128(s)
+			case "$date":
+				return convertExtJSONDate(m["$date"])
+			case "$binary":
+				return convertExtJSONBinary(m["$binary"])
+			case "$regularExpression":
+				return convertExtJSONRegex(m["$regularExpression"])
+			case "$timestamp":
+				return convertExtJSONTimestamp(m["$timestamp"])
+			case "$minKey":
+				return MinKey, nil
+			case "$maxKey":
+				return MaxKey, nil
+			case "$symbol":
+				s, err := extJSONString(m, "$symbol")
+				if err != nil {
+					return nil, err
+				}
+				return Symbol(s), nil
+			case "$undefined":
+				return Undefined, nil
+			case "$code":
+				code, err := extJSONString(m, "$code")
+				if err != nil {
+					return nil, err
+				}
+				js := JavaScript{Code: code}
+				if scope, ok := m["$scope"]; ok {
+					s, err := convertExtJSON(scope)
+					if err != nil {
+						return nil, err
+					}
+					js.Scope = s
+				}
+				return js, nil
+			case "$dbPointer":
+				return nil, fmt.Errorf("bson: $dbPointer has no native Go representation and cannot be unmarshaled")
+			}
+		}
+	}
+	out := make(M, len(m))
+	for k, e := range m {
+		c, err := convertExtJSON(e)
+		if err != nil {
+			return nil, err
+		}
+		out[k] = c
+	}
+	return out, nil
+}
+
+func has(m map[string]interface{}, key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+// extJSONString returns m[key] as a string, or a descriptive error if the
+// key is absent or holds a value of the wrong type. Extended JSON wrapper
+// fields (such as $oid or $numberLong) are always encoded as JSON strings,
+// so every caller that expects one of these fields uses this helper rather
+// than asserting the type directly, which would panic on malformed input.
+func extJSONString(m map[string]interface{}, key string) (string, error) {
+	v, ok := m[key]
+	if !ok {
+		return "", fmt.Errorf("bson: missing %q in Extended JSON value", key)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("bson: %q must be a string, got %#v", key, v)
+	}
+	return s, nil
+}
+
+// extJSONObjectId converts a $oid wrapper's string value to an ObjectId.
+// ObjectIdHex panics on malformed input, but $oid arrives from Extended
+// JSON interchange data, which may come from outside this process, so it
+// must be validated here and rejected with an error rather than crashing.
+func extJSONObjectId(s string) (ObjectId, error) {
+	if len(s) != 24 {
+		return "", fmt.Errorf("bson: invalid $oid %q: must be exactly 24 hex characters", s)
+	}
+	if _, err := hex.DecodeString(s); err != nil {
+		return "", fmt.Errorf("bson: invalid $oid %q: %v", s, err)
+	}
+	return ObjectIdHex(s), nil
+}
+
+func parseExtJSONDouble(s string) (float64, error) {
+	switch s {
+	case "NaN":
+		return math.NaN(), nil
+	case "Infinity":
+		return math.Inf(1), nil
+	case "-Infinity":
+		return math.Inf(-1), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func convertExtJSONDate(v interface{}) (time.Time, error) {
+	switch v := v.(type) {
+	case string:
+		return time.Parse("2006-01-02T15:04:05.999Z07:00", v)
+	case map[string]interface{}:
+		s, err := extJSONString(v, "$numberLong")
+		if err != nil {
+			return time.Time{}, err
+		}
+		ms, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(ms/1000, (ms%1000)*1e6).UTC(), nil
+	case json.Number:
+		// Legacy (v1) Extended JSON represents $date as a bare number of
+		// milliseconds since the epoch instead of wrapping it in
+		// $numberLong.
+		ms, err := v.Int64()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(ms/1000, (ms%1000)*1e6).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("bson: invalid $date value %#v", v)
+}
+
+// convertExtJSONRegexLegacy converts the legacy (v1) Extended JSON regular
+// expression form, {"$regex": "...", "$options": "..."}, which spells the
+// pattern and options as sibling keys rather than nesting them inside a
+// single $regularExpression wrapper as v2 does.
+func convertExtJSONRegexLegacy(m map[string]interface{}) (RegEx, error) {
+	pattern, err := extJSONString(m, "$regex")
+	if err != nil {
+		return RegEx{}, err
+	}
+	options, err := extJSONString(m, "$options")
+	if err != nil {
+		return RegEx{}, err
+	}
+	return RegEx{Pattern: pattern, Options: options}, nil
+}
+
+// convertExtJSONBinaryLegacy converts the legacy (v1) Extended JSON binary
+// form, {"$binary": "<base64>", "$type": "<hex>"}, which spells the
+// payload and subtype as sibling keys rather than nesting them inside a
+// single $binary wrapper as v2 does.
+func convertExtJSONBinaryLegacy(m map[string]interface{}) (Binary, error) {
+	b64, err := extJSONString(m, "$binary")
+	if err != nil {
+		return Binary{}, err
+	}
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return Binary{}, err
+	}
+	typ, err := extJSONString(m, "$type")
+	if err != nil {
+		return Binary{}, err
+	}
+	subtype, err := strconv.ParseUint(typ, 16, 8)
+	if err != nil {
+		return Binary{}, err
+	}
+	return Binary{Kind: byte(subtype), Data: data}, nil
+}
+
+func convertExtJSONBinary(v interface{}) (Binary, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return Binary{}, fmt.Errorf("bson: invalid $binary value %#v", v)
+	}
+	b64, err := extJSONString(m, "base64")
+	if err != nil {
+		return Binary{}, err
+	}
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return Binary{}, err
+	}
+	subType, err := extJSONString(m, "subType")
+	if err != nil {
+		return Binary{}, err
+	}
+	subtype, err := strconv.ParseUint(subType, 16, 8)
+	if err != nil {
+		return Binary{}, err
+	}
+	return Binary{Kind: byte(subtype), Data: data}, nil
+}
+
+func convertExtJSONRegex(v interface{}) (RegEx, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return RegEx{}, fmt.Errorf("bson: invalid $regularExpression value %#v", v)
+	}
+	pattern, err := extJSONString(m, "pattern")
+	if err != nil {
+		return RegEx{}, err
+	}
+	options, err := extJSONString(m, "options")
+	if err != nil {
+		return RegEx{}, err
+	}
+	return RegEx{Pattern: pattern, Options: options}, nil
+}
+
+func convertExtJSONTimestamp(v interface{}) (MongoTimestamp, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("bson: invalid $timestamp value %#v", v)
+	}
+	tn, ok := m["t"].(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("bson: $timestamp.t must be a number, got %#v", m["t"])
+	}
+	t, err := tn.Int64()
+	if err != nil {
+		return 0, err
+	}
+	in, ok := m["i"].(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("bson: $timestamp.i must be a number, got %#v", m["i"])
+	}
+	i, err := in.Int64()
+	if err != nil {
+		return 0, err
+	}
+	return MongoTimestamp(t<<32 | i), nil
+}
@@ -0,0 +1,409 @@
+package bson
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestConvertExtJSONLegacyRegex covers the legacy (v1) Extended JSON form
+// for regular expressions, {"$regex": ..., "$options": ...}, alongside the
+// v2 {"$regularExpression": {...}} form covered by
+// TestConvertExtJSONRegex below.
+func TestConvertExtJSONLegacyRegex(t *testing.T) {
+	v, err := convertExtJSON(map[string]interface{}{"$regex": "^a", "$options": "i"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	re, ok := v.(RegEx)
+	if !ok {
+		t.Fatalf("convertExtJSON returned %#v, want RegEx", v)
+	}
+	if re.Pattern != "^a" || re.Options != "i" {
+		t.Fatalf("got %+v, want {^a i}", re)
+	}
+}
+
+// TestConvertExtJSONRegex covers the v2 Extended JSON form for regular
+// expressions, {"$regularExpression": {"pattern": ..., "options": ...}}.
+func TestConvertExtJSONRegex(t *testing.T) {
+	v, err := convertExtJSON(map[string]interface{}{
+		"$regularExpression": map[string]interface{}{"pattern": "^a", "options": "i"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	re, ok := v.(RegEx)
+	if !ok {
+		t.Fatalf("convertExtJSON returned %#v, want RegEx", v)
+	}
+	if re.Pattern != "^a" || re.Options != "i" {
+		t.Fatalf("got %+v, want {^a i}", re)
+	}
+}
+
+// TestConvertExtJSONLegacyBinary covers the legacy (v1) Extended JSON form
+// for binary data, {"$binary": "<base64>", "$type": "<hex>"}.
+func TestConvertExtJSONLegacyBinary(t *testing.T) {
+	v, err := convertExtJSON(map[string]interface{}{"$binary": "aGVsbG8=", "$type": "03"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, ok := v.(Binary)
+	if !ok {
+		t.Fatalf("convertExtJSON returned %#v, want Binary", v)
+	}
+	if string(b.Data) != "hello" || b.Kind != 0x03 {
+		t.Fatalf("got %+v, want {Kind:3 Data:hello}", b)
+	}
+}
+
+// TestConvertExtJSONLegacyDate covers the legacy (v1) Extended JSON form
+// for dates, a bare number of milliseconds since the epoch, as opposed to
+// the v2 {"$date": {"$numberLong": "..."}} wrapper.
+func TestConvertExtJSONLegacyDate(t *testing.T) {
+	v, err := convertExtJSON(map[string]interface{}{"$date": json.Number("1000")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := v.(time.Time)
+	if !ok {
+		t.Fatalf("convertExtJSON returned %#v, want time.Time", v)
+	}
+	want := time.Unix(1, 0).UTC()
+	if !got.Equal(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestConvertExtJSONDateISOString(t *testing.T) {
+	v, err := convertExtJSON(map[string]interface{}{"$date": "1970-01-01T00:00:01Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := v.(time.Time)
+	if !ok {
+		t.Fatalf("convertExtJSON returned %#v, want time.Time", v)
+	}
+	want := time.Unix(1, 0).UTC()
+	if !got.Equal(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestConvertExtJSONObjectId(t *testing.T) {
+	v, err := convertExtJSON(map[string]interface{}{"$oid": "4d88e15b60f486e428412dc9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, ok := v.(ObjectId)
+	if !ok || id.Hex() != "4d88e15b60f486e428412dc9" {
+		t.Fatalf("got %#v", v)
+	}
+}
+
+// TestConvertExtJSONObjectIdInvalid checks that a malformed $oid is
+// reported as an error rather than reaching ObjectIdHex, which panics on
+// anything that isn't exactly 12 bytes of hex. $oid values arrive from
+// Extended JSON interchange data, so malformed input must not crash the
+// process.
+func TestConvertExtJSONObjectIdInvalid(t *testing.T) {
+	cases := []string{"not-a-valid-id", "4d88e15b60f486e428412dc", "4d88e15b60f486e428412dc9ab"}
+	for _, s := range cases {
+		if _, err := convertExtJSON(map[string]interface{}{"$oid": s}); err == nil {
+			t.Errorf("convertExtJSON($oid: %q) expected error, got none", s)
+		}
+	}
+}
+
+// TestUnmarshalExtJSONInvalidObjectId checks the same thing through the
+// public UnmarshalExtJSON entry point, which previously had no
+// defer handleErr(&err) to catch a panic from this path.
+func TestUnmarshalExtJSONInvalidObjectId(t *testing.T) {
+	var out M
+	err := UnmarshalExtJSON([]byte(`{"$oid":"not-a-valid-id"}`), true, &out)
+	if err == nil {
+		t.Fatal("UnmarshalExtJSON with a malformed $oid expected an error, got none")
+	}
+}
+
+func TestConvertExtJSONPlainObject(t *testing.T) {
+	v, err := convertExtJSON(map[string]interface{}{"a": json.Number("1"), "b": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := v.(M)
+	if !ok {
+		t.Fatalf("convertExtJSON returned %#v, want M", v)
+	}
+	if m["a"] != int32(1) || m["b"] != "x" {
+		t.Fatalf("got %+v", m)
+	}
+}
+
+// --------------------------------------------------------------------------
+// MarshalExtJSON / UnmarshalExtJSON
+//
+// These exercise the public API end to end, as opposed to the
+// convertExtJSON/writeExtJSONValue tests above and below, which call the
+// internal helpers directly.
+
+type extJSONDoc struct {
+	Id    ObjectId   `bson:"_id"`
+	Name  string     `bson:"name"`
+	Count int64      `bson:"count"`
+	When  time.Time  `bson:"when"`
+	Blob  Binary     `bson:"blob"`
+	Dec   Decimal128 `bson:"dec"`
+}
+
+func TestMarshalUnmarshalExtJSONRoundTrip(t *testing.T) {
+	dec, err := ParseDecimal128("1.50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := extJSONDoc{
+		Id:    NewObjectId(),
+		Name:  "widget",
+		Count: 42,
+		When:  time.Unix(1000, 0).UTC(),
+		Blob:  Binary{Kind: 0x00, Data: []byte("hello")},
+		Dec:   dec,
+	}
+
+	for _, canonical := range []bool{true, false} {
+		data, err := MarshalExtJSON(in, canonical, false)
+		if err != nil {
+			t.Fatalf("MarshalExtJSON(canonical=%v): %v", canonical, err)
+		}
+
+		// In canonical mode every number is wrapped; in relaxed mode the
+		// int64 count is emitted as a bare JSON number.
+		if canonical && !bytes.Contains(data, []byte(`"$numberLong":"42"`)) {
+			t.Fatalf("canonical MarshalExtJSON did not wrap count: %s", data)
+		}
+		if !canonical && !bytes.Contains(data, []byte(`"count":42`)) {
+			t.Fatalf("relaxed MarshalExtJSON did not emit a bare count: %s", data)
+		}
+
+		var out extJSONDoc
+		if err := UnmarshalExtJSON(data, canonical, &out); err != nil {
+			t.Fatalf("UnmarshalExtJSON(canonical=%v): %v", canonical, err)
+		}
+		if out.Id != in.Id || out.Name != in.Name || out.Count != in.Count {
+			t.Fatalf("canonical=%v: round trip mismatch, got %+v, want %+v", canonical, out, in)
+		}
+		if !out.When.Equal(in.When) {
+			t.Fatalf("canonical=%v: When = %v, want %v", canonical, out.When, in.When)
+		}
+		if string(out.Blob.Data) != string(in.Blob.Data) || out.Blob.Kind != in.Blob.Kind {
+			t.Fatalf("canonical=%v: Blob = %+v, want %+v", canonical, out.Blob, in.Blob)
+		}
+		if out.Dec.String() != in.Dec.String() {
+			t.Fatalf("canonical=%v: Dec = %s, want %s", canonical, out.Dec.String(), in.Dec.String())
+		}
+	}
+}
+
+// TestExtJSONRelaxedGenericInt32RoundTrip checks that decoding a relaxed-mode
+// bare integer into a generic M preserves the original BSON kind (0x10 for
+// int32), rather than always widening it to int64 (0x12) as a subsequent
+// Marshal would if UnmarshalExtJSON picked int64 unconditionally.
+func TestExtJSONRelaxedGenericInt32RoundTrip(t *testing.T) {
+	in := struct {
+		A int32 `bson:"a"`
+	}{A: 7}
+
+	data, err := MarshalExtJSON(in, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"a":7`)) {
+		t.Fatalf("relaxed MarshalExtJSON did not emit a bare a: %s", data)
+	}
+
+	var out M
+	if err := UnmarshalExtJSON(data, false, &out); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := out["a"].(int32); !ok {
+		t.Fatalf("out[%q] = %#v, want int32", "a", out["a"])
+	}
+
+	roundTripped, err := Marshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(roundTripped, original) {
+		t.Fatalf("Marshal(out) = %x, want %x (kind changed across round trip)", roundTripped, original)
+	}
+}
+
+// --------------------------------------------------------------------------
+// BSON -> Extended JSON (writeExtJSONValue)
+//
+// These call writeExtJSONValue directly with hand-built element payloads,
+// the same technique buildRawDoc (raw_test.go) uses to test Raw.Elements
+// without depending on Marshal.
+
+func writeExtJSONValueString(t *testing.T, kind byte, data []byte, canonical bool) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := writeExtJSONValue(&buf, kind, data, canonical, false); err != nil {
+		t.Fatalf("writeExtJSONValue: %v", err)
+	}
+	return buf.String()
+}
+
+func TestWriteExtJSONValueBinary(t *testing.T) {
+	data := append([]byte{4, 0, 0, 0, 0x03}, []byte("hey!")...)
+	got := writeExtJSONValueString(t, 0x05, data, true)
+	want := `{"$binary":{"base64":"aGV5IQ==","subType":"03"}}`
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestWriteExtJSONValueRegex(t *testing.T) {
+	data := append([]byte("^a"), 0)
+	data = append(data, "i"...)
+	data = append(data, 0)
+	got := writeExtJSONValueString(t, 0x0B, data, true)
+	want := `{"$regularExpression":{"pattern":"^a","options":"i"}}`
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestWriteExtJSONValueDBPointer(t *testing.T) {
+	var data []byte
+	var lb [4]byte
+	binary.LittleEndian.PutUint32(lb[:], uint32(len("db.coll")+1))
+	data = append(data, lb[:]...)
+	data = append(data, "db.coll"...)
+	data = append(data, 0)
+	oid := ObjectIdHex("4d88e15b60f486e428412dc9")
+	data = append(data, []byte(oid)...)
+
+	got := writeExtJSONValueString(t, 0x0C, data, true)
+	want := `{"$dbPointer":{"$ref":"db.coll","$id":{"$oid":"4d88e15b60f486e428412dc9"}}}`
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestWriteExtJSONValueCodeWithScope(t *testing.T) {
+	scope := buildRawDoc(rawTestElem{name: "x", value: int32(1)})
+	code := "function() {}"
+
+	var codeBuf []byte
+	var lb [4]byte
+	binary.LittleEndian.PutUint32(lb[:], uint32(len(code)+1))
+	codeBuf = append(codeBuf, lb[:]...)
+	codeBuf = append(codeBuf, code...)
+	codeBuf = append(codeBuf, 0)
+	codeBuf = append(codeBuf, scope...)
+
+	var total [4]byte
+	binary.LittleEndian.PutUint32(total[:], uint32(4+len(codeBuf)))
+	data := append(total[:], codeBuf...)
+
+	got := writeExtJSONValueString(t, 0x0F, data, true)
+	want := `{"$code":"function() {}","$scope":{"x":{"$numberInt":"1"}}}`
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestWriteExtJSONValueDecimal128(t *testing.T) {
+	d, err := ParseDecimal128("1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := d.GetBSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawVal := raw.(Raw)
+	got := writeExtJSONValueString(t, rawVal.Kind, rawVal.Data, true)
+	want := `{"$numberDecimal":"1.5"}`
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestWriteExtJSONValueTimestamp(t *testing.T) {
+	var data [8]byte
+	binary.LittleEndian.PutUint32(data[0:4], 7)  // increment
+	binary.LittleEndian.PutUint32(data[4:8], 42) // seconds
+	got := writeExtJSONValueString(t, 0x11, data[:], true)
+	want := `{"$timestamp":{"t":42,"i":7}}`
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+// TestWriteExtJSONValueMalformedDBPointerNoPanic reproduces the crash a
+// dbpointer element with a corrupted (negative) ref-string length used to
+// cause: writeExtJSONValue sliced straight past the buffer instead of
+// reporting an error.
+func TestWriteExtJSONValueMalformedDBPointerNoPanic(t *testing.T) {
+	var data []byte
+	var lb [4]byte
+	binary.LittleEndian.PutUint32(lb[:], 0xFFFFFFFF) // -1
+	data = append(data, lb[:]...)
+	data = append(data, make([]byte, 12)...)
+
+	var buf bytes.Buffer
+	err := writeExtJSONValue(&buf, 0x0C, data, true, false)
+	if err == nil {
+		t.Fatal("expected an error for a malformed dbpointer, got none")
+	}
+}
+
+// TestWriteExtJSONValueMalformedCodeWithScopeNoPanic reproduces the crash a
+// code-with-scope element with a corrupted (negative) total length and a
+// zero inner code length used to cause.
+func TestWriteExtJSONValueMalformedCodeWithScopeNoPanic(t *testing.T) {
+	var data []byte
+	var lb [4]byte
+	binary.LittleEndian.PutUint32(lb[:], 0xFFFFFFFF) // -1
+	data = append(data, lb[:]...)
+	data = append(data, make([]byte, 12)...)
+
+	var buf bytes.Buffer
+	err := writeExtJSONValue(&buf, 0x0F, data, true, false)
+	if err == nil {
+		t.Fatal("expected an error for a malformed code-with-scope value, got none")
+	}
+}
+
+// TestRawParseMalformedDBPointerNoPanic confirms parseRawDoc itself (the
+// path used by Raw.Elements, Raw.Lookup and MarshalExtJSON) rejects a
+// dbpointer element with a corrupted ref-string length instead of letting
+// it through as a truncated, wrong-length element.
+func TestRawParseMalformedDBPointerNoPanic(t *testing.T) {
+	var elem []byte
+	elem = append(elem, 0x0C)
+	elem = append(elem, 'x', 0)
+	var lb [4]byte
+	binary.LittleEndian.PutUint32(lb[:], 0xFFFFFFFF) // -1
+	elem = append(elem, lb[:]...)
+	elem = append(elem, make([]byte, 12)...)
+
+	total := 4 + len(elem) + 1
+	var tb [4]byte
+	binary.LittleEndian.PutUint32(tb[:], uint32(total))
+	doc := append(append([]byte{}, tb[:]...), elem...)
+	doc = append(doc, 0)
+
+	if _, err := parseRawDoc(doc); err == nil {
+		t.Fatal("expected an error for a malformed dbpointer element, got none")
+	}
+}
@@ -0,0 +1,72 @@
+package bson
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestNewObjectIdUnique(t *testing.T) {
+	seen := make(map[ObjectId]bool)
+	for i := 0; i < 1000; i++ {
+		id := NewObjectId()
+		if !id.Valid() {
+			t.Fatalf("NewObjectId produced invalid id: %x", string(id))
+		}
+		if seen[id] {
+			t.Fatalf("NewObjectId produced duplicate id: %x", string(id))
+		}
+		seen[id] = true
+	}
+}
+
+func TestObjectIdProcessUnique(t *testing.T) {
+	id := NewObjectId()
+	if got := id.Machine(); len(got) != 3 {
+		t.Fatalf("Machine() returned %d bytes, want 3", len(got))
+	}
+	pu := ProcessUnique()
+	if len(pu) != 5 {
+		t.Fatalf("ProcessUnique() returned %d bytes, want 5", len(pu))
+	}
+}
+
+func TestSetObjectIdGenerator(t *testing.T) {
+	custom := ObjectIdHex("4d88e15b60f486e428412dc9")
+	SetObjectIdGenerator(func() ObjectId { return custom })
+	defer SetObjectIdGenerator(nil)
+
+	if got := NewObjectId(); got != custom {
+		t.Fatalf("NewObjectId() = %x, want %x", string(got), string(custom))
+	}
+}
+
+// TestSetObjectIdGeneratorRace exercises SetObjectIdGenerator concurrently
+// with NewObjectId; run with -race to catch a data race on the generator.
+func TestSetObjectIdGeneratorRace(t *testing.T) {
+	defer SetObjectIdGenerator(nil)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		gen := func() ObjectId { return ObjectIdHex("4d88e15b60f486e428412dc9") }
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				SetObjectIdGenerator(gen)
+				SetObjectIdGenerator(nil)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			NewObjectId()
+		}
+		close(stop)
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,229 @@
+package bson
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// RawDocElem is a single named element within a RawD, holding the raw,
+// undecoded BSON value for the given name.
+type RawDocElem struct {
+	Name  string
+	Value Raw
+}
+
+// RawD is an ordered list of RawDocElem, analogous to D but without
+// decoding element values. It's produced by Raw.Elements, which lets
+// callers iterate a document's elements in their original order without
+// materializing anything beyond the slice itself.
+type RawD []RawDocElem
+
+var rawDType = reflect.TypeOf(RawD(nil))
+
+// Elements returns the top-level elements of r in document order without
+// decoding any of their values. r must hold a document or an array (kind
+// 0x03 or 0x04). The returned values reference r's own backing array, so
+// they remain valid only as long as r's does.
+func (r Raw) Elements() ([]RawDocElem, error) {
+	switch r.Kind {
+	case 0x03, 0x04:
+	default:
+		return nil, &TypeError{rawDType, r.Kind}
+	}
+	elems, err := parseRawDoc(r.Data)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]RawDocElem, len(elems))
+	for i, e := range elems {
+		out[i] = RawDocElem{Name: e.Name, Value: Raw{Kind: e.Kind, Data: e.Data}}
+	}
+	return out, nil
+}
+
+// Lookup walks path into r, descending into embedded documents and, by
+// numeric string index, arrays at each step, and returns the Raw value
+// found at the end of the path. It returns false if any step along the
+// path doesn't exist. No value along the way is decoded beyond what's
+// needed to find the next element, so the operation is allocation-free
+// apart from the element slices and element names built up by Elements.
+// The returned Raw's
+// Data references r's own backing array.
+func (r Raw) Lookup(path ...string) (Raw, bool) {
+	cur := r
+	for _, key := range path {
+		elems, err := cur.Elements()
+		if err != nil {
+			return Raw{}, false
+		}
+		found := false
+		for _, e := range elems {
+			if e.Name == key {
+				cur = e.Value
+				found = true
+				break
+			}
+		}
+		if !found {
+			return Raw{}, false
+		}
+	}
+	return cur, true
+}
+
+// rawElement, parseRawDoc and rawValueLen are the low-level element-header
+// iterator that both writeExtJSONDoc/writeExtJSONArray (Extended JSON
+// conversion) and Raw.Elements/Raw.Lookup (zero-copy field access) are
+// built on. They landed in this file because Extended JSON conversion
+// needed them first; Raw.Elements and Raw.Lookup above reuse rather than
+// duplicate them.
+//
+// rawElement is a single parsed BSON element: its kind, its element name,
+// and the byte slice — a subslice of the original document — holding its
+// value payload exactly as it appears on the wire.
+type rawElement struct {
+	Kind byte
+	Name string
+	Data []byte
+}
+
+// parseRawDoc walks the elements of a complete BSON document, including
+// its leading 4-byte length prefix and trailing NUL, without copying any
+// element payloads.
+func parseRawDoc(data []byte) ([]rawElement, error) {
+	if len(data) < 5 {
+		return nil, errors.New("bson: document too short")
+	}
+	length := int(int32(binary.LittleEndian.Uint32(data)))
+	if length < 5 || length > len(data) {
+		return nil, errors.New("bson: invalid document length")
+	}
+	body := data[4 : length-1]
+	var elems []rawElement
+	for len(body) > 0 {
+		kind := body[0]
+		body = body[1:]
+		i := indexNUL(body)
+		if i < 0 {
+			return nil, errors.New("bson: invalid document: unterminated element name")
+		}
+		name := string(body[:i])
+		body = body[i+1:]
+		n, err := rawValueLen(kind, body)
+		if err != nil {
+			return nil, err
+		}
+		if n > len(body) {
+			return nil, errors.New("bson: invalid document: truncated element")
+		}
+		elems = append(elems, rawElement{Kind: kind, Name: name, Data: body[:n]})
+		body = body[n:]
+	}
+	return elems, nil
+}
+
+func indexNUL(b []byte) int {
+	for i, c := range b {
+		if c == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// rawValueLen returns the number of bytes occupied by the value of a BSON
+// element of the given kind, given the bytes immediately following its
+// name. It does not copy or interpret the value itself.
+func rawValueLen(kind byte, data []byte) (int, error) {
+	switch kind {
+	case 0x01, 0x09, 0x11, 0x12: // double, datetime, timestamp, int64
+		return 8, nil
+	case 0x02, 0x0D, 0x0E: // string, javascript, symbol
+		if len(data) < 4 {
+			return 0, errors.New("bson: truncated string length")
+		}
+		n := int(int32(binary.LittleEndian.Uint32(data)))
+		// n counts the trailing NUL, so the minimum valid encoding (an
+		// empty string) has n == 1; anything less can't be re-sliced
+		// without its own trailing NUL byte.
+		if n < 1 {
+			return 0, errors.New("bson: invalid string length")
+		}
+		if 4+n > len(data) {
+			return 0, errors.New("bson: invalid string length")
+		}
+		return 4 + n, nil
+	case 0x03, 0x04: // document, array
+		if len(data) < 4 {
+			return 0, errors.New("bson: truncated document length")
+		}
+		n := int(int32(binary.LittleEndian.Uint32(data)))
+		if n < 5 {
+			return 0, errors.New("bson: invalid document length")
+		}
+		return n, nil
+	case 0x05: // binary
+		if len(data) < 4 {
+			return 0, errors.New("bson: truncated binary length")
+		}
+		n := int(int32(binary.LittleEndian.Uint32(data)))
+		if n < 0 {
+			return 0, errors.New("bson: invalid binary length")
+		}
+		return 4 + 1 + n, nil
+	case 0x06, 0x0A, 0xFF, 0x7F: // undefined, null, minkey, maxkey
+		return 0, nil
+	case 0x07: // objectid
+		return 12, nil
+	case 0x08: // bool
+		return 1, nil
+	case 0x0B: // regex: pattern cstring, options cstring
+		i := indexNUL(data)
+		if i < 0 {
+			return 0, errors.New("bson: invalid regex: missing pattern terminator")
+		}
+		j := indexNUL(data[i+1:])
+		if j < 0 {
+			return 0, errors.New("bson: invalid regex: missing options terminator")
+		}
+		return i + 1 + j + 1, nil
+	case 0x0C: // dbpointer: string + 12-byte objectid
+		if len(data) < 4 {
+			return 0, errors.New("bson: truncated dbpointer")
+		}
+		n := int(int32(binary.LittleEndian.Uint32(data)))
+		// n is the ref string's own length prefix, which like any BSON
+		// string counts its trailing NUL, so it must be at least 1.
+		if n < 1 {
+			return 0, errors.New("bson: invalid dbpointer length")
+		}
+		total := 4 + n + 12
+		if total > len(data) {
+			return 0, errors.New("bson: invalid dbpointer length")
+		}
+		return total, nil
+	case 0x0F: // code with scope: total length prefix
+		if len(data) < 8 {
+			return 0, errors.New("bson: truncated code-with-scope length")
+		}
+		n := int(int32(binary.LittleEndian.Uint32(data)))
+		if n < 4 || n > len(data) {
+			return 0, errors.New("bson: invalid code-with-scope length")
+		}
+		// The code string's own length prefix follows immediately; cross-
+		// check it against the outer total rather than trusting it again,
+		// unchecked, when writeExtJSONValue re-reads it.
+		codeLen := int(int32(binary.LittleEndian.Uint32(data[4:])))
+		if codeLen < 1 || 8+codeLen > n {
+			return 0, errors.New("bson: invalid code-with-scope code length")
+		}
+		return n, nil
+	case 0x10: // int32
+		return 4, nil
+	case 0x13: // decimal128
+		return 16, nil
+	}
+	return 0, fmt.Errorf("bson: unknown element kind 0x%02x", kind)
+}
@@ -0,0 +1,160 @@
+package bson
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// buildRawDoc hand-assembles a minimal BSON document byte slice from a list
+// of (name, value) pairs, where value is either int32, string, or []byte
+// (a pre-built nested document/array). It exists purely to give these
+// tests raw bytes to walk without depending on Marshal.
+func buildRawDoc(elems ...rawTestElem) []byte {
+	var body []byte
+	for _, e := range elems {
+		switch v := e.value.(type) {
+		case int32:
+			body = append(body, 0x10)
+			body = append(body, e.name...)
+			body = append(body, 0)
+			var b [4]byte
+			binary.LittleEndian.PutUint32(b[:], uint32(v))
+			body = append(body, b[:]...)
+		case string:
+			body = append(body, 0x02)
+			body = append(body, e.name...)
+			body = append(body, 0)
+			var b [4]byte
+			binary.LittleEndian.PutUint32(b[:], uint32(len(v)+1))
+			body = append(body, b[:]...)
+			body = append(body, v...)
+			body = append(body, 0)
+		case []byte:
+			body = append(body, e.kind)
+			body = append(body, e.name...)
+			body = append(body, 0)
+			body = append(body, v...)
+		default:
+			panic("buildRawDoc: unsupported value type")
+		}
+	}
+	total := 4 + len(body) + 1
+	out := make([]byte, 0, total)
+	var lb [4]byte
+	binary.LittleEndian.PutUint32(lb[:], uint32(total))
+	out = append(out, lb[:]...)
+	out = append(out, body...)
+	out = append(out, 0)
+	return out
+}
+
+type rawTestElem struct {
+	name  string
+	value interface{}
+	kind  byte // only used when value is []byte
+}
+
+func TestRawElements(t *testing.T) {
+	doc := buildRawDoc(
+		rawTestElem{name: "a", value: int32(1)},
+		rawTestElem{name: "b", value: "hi"},
+	)
+	r := Raw{Kind: 0x03, Data: doc}
+
+	elems, err := r.Elements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elems) != 2 {
+		t.Fatalf("got %d elements, want 2", len(elems))
+	}
+	if elems[0].Name != "a" || elems[0].Value.Kind != 0x10 {
+		t.Fatalf("elems[0] = %+v", elems[0])
+	}
+	if elems[1].Name != "b" || elems[1].Value.Kind != 0x02 {
+		t.Fatalf("elems[1] = %+v", elems[1])
+	}
+}
+
+func TestRawElementsWrongKind(t *testing.T) {
+	r := Raw{Kind: 0x10, Data: []byte{1, 0, 0, 0}}
+	if _, err := r.Elements(); err == nil {
+		t.Fatal("expected error for non-document/array Raw")
+	}
+}
+
+func TestRawLookupTopLevel(t *testing.T) {
+	doc := buildRawDoc(rawTestElem{name: "a", value: int32(42)})
+	r := Raw{Kind: 0x03, Data: doc}
+
+	v, ok := r.Lookup("a")
+	if !ok {
+		t.Fatal("Lookup(\"a\") not found")
+	}
+	if v.Kind != 0x10 {
+		t.Fatalf("got kind 0x%02x, want 0x10", v.Kind)
+	}
+	if n := int32(binary.LittleEndian.Uint32(v.Data)); n != 42 {
+		t.Fatalf("got %d, want 42", n)
+	}
+
+	if _, ok := r.Lookup("missing"); ok {
+		t.Fatal("Lookup(\"missing\") unexpectedly found")
+	}
+}
+
+func TestRawLookupNested(t *testing.T) {
+	inner := buildRawDoc(rawTestElem{name: "c", value: "nested"})
+	outer := buildRawDoc(rawTestElem{name: "b", value: inner, kind: 0x03})
+	r := Raw{Kind: 0x03, Data: outer}
+
+	v, ok := r.Lookup("b", "c")
+	if !ok {
+		t.Fatal("Lookup(\"b\", \"c\") not found")
+	}
+	if v.Kind != 0x02 {
+		t.Fatalf("got kind 0x%02x, want 0x02 (string)", v.Kind)
+	}
+
+	if _, ok := r.Lookup("b", "missing"); ok {
+		t.Fatal("Lookup(\"b\", \"missing\") unexpectedly found")
+	}
+	if _, ok := r.Lookup("missing", "c"); ok {
+		t.Fatal("Lookup(\"missing\", \"c\") unexpectedly found")
+	}
+}
+
+// TestRawLookupAliasesBackingArray confirms the Raw values returned by
+// Lookup reference the original document's backing array rather than a
+// copy, as documented.
+func TestRawLookupAliasesBackingArray(t *testing.T) {
+	doc := buildRawDoc(rawTestElem{name: "a", value: int32(7)})
+	r := Raw{Kind: 0x03, Data: doc}
+
+	v, ok := r.Lookup("a")
+	if !ok {
+		t.Fatal("Lookup(\"a\") not found")
+	}
+	// Mutate the original backing array and confirm the looked-up value
+	// observes the change, proving it's not a copy.
+	doc[len(doc)-5] = 0xFF
+	if v.Data[0] != 0xFF {
+		t.Fatalf("Lookup result doesn't alias the source document's backing array")
+	}
+}
+
+func TestRawDType(t *testing.T) {
+	doc := buildRawDoc(
+		rawTestElem{name: "a", value: int32(1)},
+		rawTestElem{name: "b", value: int32(2)},
+	)
+	r := Raw{Kind: 0x03, Data: doc}
+	elems, err := r.Elements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rd RawD = elems
+	if len(rd) != 2 || rd[0].Name != "a" || rd[1].Name != "b" {
+		t.Fatalf("got %+v", rd)
+	}
+}
@@ -0,0 +1,131 @@
+package bson
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxDocumentSize is the maximum document size accepted or
+// produced by an Encoder or Decoder when no explicit limit has been set
+// with SetMaxDocumentSize, matching MongoDB's own maxBsonObjectSize.
+const DefaultMaxDocumentSize = 16 * 1024 * 1024
+
+// Encoder writes a stream of BSON documents to an underlying io.Writer.
+// Successive calls to Encode write each document back-to-back with no
+// separator, as mongodump and the MongoDB wire protocol do.
+type Encoder struct {
+	w          io.Writer
+	maxDocSize int
+}
+
+// NewEncoder returns a new Encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, maxDocSize: DefaultMaxDocumentSize}
+}
+
+// SetMaxDocumentSize changes the maximum encoded document size the
+// Encoder will write before returning an error instead. A size of 0
+// disables the guard.
+func (e *Encoder) SetMaxDocumentSize(n int) {
+	e.maxDocSize = n
+}
+
+// Encode marshals v, which follows the same rules as Marshal, and writes
+// the resulting document to the underlying writer.
+func (e *Encoder) Encode(v interface{}) error {
+	data, err := Marshal(v)
+	if err != nil {
+		return err
+	}
+	if e.maxDocSize > 0 && len(data) > e.maxDocSize {
+		return fmt.Errorf("bson: encoded document of length %d exceeds maximum of %d", len(data), e.maxDocSize)
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+// Decoder reads a stream of BSON documents from an underlying io.Reader.
+type Decoder struct {
+	r          *bufio.Reader
+	maxDocSize int
+	buf        []byte
+}
+
+// NewDecoder returns a new Decoder that reads from r. The decoder rejects
+// any document whose declared length exceeds DefaultMaxDocumentSize;
+// use SetMaxDocumentSize to change the limit.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r), maxDocSize: DefaultMaxDocumentSize}
+}
+
+// SetMaxDocumentSize changes the maximum document size the Decoder will
+// accept before returning an error instead. A size of 0 disables the
+// guard.
+func (d *Decoder) SetMaxDocumentSize(n int) {
+	d.maxDocSize = n
+}
+
+// Buffered returns a reader of the bytes already read into the Decoder's
+// internal buffer but not yet consumed by Decode or DecodeRaw.
+func (d *Decoder) Buffered() io.Reader {
+	b, _ := d.r.Peek(d.r.Buffered())
+	return bytes.NewReader(b)
+}
+
+// Decode reads the next BSON document from the stream and stores the
+// result in v, which follows the same rules as the out parameter of
+// Unmarshal. It returns io.EOF once the stream is exhausted.
+func (d *Decoder) Decode(v interface{}) error {
+	data, err := d.readDoc()
+	if err != nil {
+		return err
+	}
+	return Unmarshal(data, v)
+}
+
+// DecodeRaw reads the next BSON document from the stream without decoding
+// it, returning it as a Raw value. The returned Raw is backed by the
+// Decoder's own internal buffer, not a fresh allocation, so it's valid
+// only until the next call to Decode or DecodeRaw overwrites that buffer;
+// callers that need to retain the bytes past that point must copy them,
+// the same contract as the Raw values returned by Raw.Lookup and
+// Raw.Elements. DecodeRaw returns io.EOF once the stream is exhausted.
+func (d *Decoder) DecodeRaw() (Raw, error) {
+	data, err := d.readDoc()
+	if err != nil {
+		return Raw{}, err
+	}
+	return Raw{Kind: 0x03, Data: data}, nil
+}
+
+// readDoc reads the next length-prefixed document into the Decoder's
+// reusable internal buffer and returns it, growing the buffer as needed.
+// The returned slice aliases that buffer and is only valid until the next
+// call to readDoc.
+func (d *Decoder) readDoc() ([]byte, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(d.r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	n := int(int32(binary.LittleEndian.Uint32(lenBuf[:])))
+	if n < 5 {
+		return nil, errors.New("bson: invalid document length")
+	}
+	if d.maxDocSize > 0 && n > d.maxDocSize {
+		return nil, fmt.Errorf("bson: document of length %d exceeds maximum of %d", n, d.maxDocSize)
+	}
+	if cap(d.buf) < n {
+		d.buf = make([]byte, n)
+	} else {
+		d.buf = d.buf[:n]
+	}
+	copy(d.buf, lenBuf[:])
+	if _, err := io.ReadFull(d.r, d.buf[4:]); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return d.buf, nil
+}
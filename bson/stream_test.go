@@ -0,0 +1,82 @@
+package bson
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	docs := []M{{"a": int32(1)}, {"a": int32(2)}, {"a": int32(3)}}
+	for _, d := range docs {
+		if err := enc.Encode(d); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+	}
+
+	dec := NewDecoder(&buf)
+	for _, want := range docs {
+		var got M
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if got["a"] != want["a"] {
+			t.Fatalf("Decode = %v, want %v", got, want)
+		}
+	}
+	if _, err := dec.readDoc(); err != io.EOF {
+		t.Fatalf("expected io.EOF at end of stream, got %v", err)
+	}
+}
+
+// TestDecodeRawAliasesBuffer confirms DecodeRaw hands back a slice of the
+// Decoder's own internal buffer rather than a fresh copy, and that the
+// data is only valid until the next Decode/DecodeRaw call overwrites it.
+func TestDecodeRawAliasesBuffer(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.Encode(M{"a": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(M{"a": 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewDecoder(&buf)
+	first, err := dec.DecodeRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstCopy := append([]byte(nil), first.Data...)
+
+	second, err := dec.DecodeRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second read overwrote the shared buffer, so first.Data (which
+	// aliases it) no longer matches the bytes it held right after the
+	// first DecodeRaw call.
+	if bytes.Equal(first.Data, firstCopy) {
+		t.Fatalf("expected DecodeRaw's buffer to be reused across calls")
+	}
+	if len(second.Data) == 0 {
+		t.Fatalf("expected second document to be decoded")
+	}
+}
+
+func TestDecoderMaxDocumentSize(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.Encode(M{"a": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewDecoder(&buf)
+	dec.SetMaxDocumentSize(4)
+	if _, err := dec.DecodeRaw(); err == nil {
+		t.Fatalf("expected error for document exceeding max size")
+	}
+}
@@ -0,0 +1,77 @@
+package bson
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type validatedStruct struct {
+	Name string
+	err  error
+}
+
+func (v *validatedStruct) Validate() error {
+	return v.err
+}
+
+type novalidateOuter struct {
+	Inner validatedStruct `bson:",inline,novalidate"`
+}
+
+type inlineOuter struct {
+	Inner validatedStruct `bson:",inline"`
+}
+
+func TestValidateValueCallsValidator(t *testing.T) {
+	v := validatedStruct{Name: "ok"}
+	if err := validateValue(reflect.ValueOf(&v).Elem()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := errors.New("boom")
+	v.err = want
+	if err := validateValue(reflect.ValueOf(&v).Elem()); err != want {
+		t.Fatalf("got %v, want %v", err, want)
+	}
+}
+
+func TestValidateValueNonAddressablePointerReceiver(t *testing.T) {
+	// validatedStruct.Validate has a pointer receiver, so a bare,
+	// non-addressable struct value can't satisfy Validator and is
+	// silently skipped; this is why Marshal takes an addressable copy of
+	// its argument before calling validateValue (see callValidate).
+	v := validatedStruct{err: errors.New("should not surface")}
+	if err := validateValue(reflect.ValueOf(v)); err != nil {
+		t.Fatalf("unexpected error from non-addressable value: %v", err)
+	}
+}
+
+func TestValidateValueInlineStruct(t *testing.T) {
+	want := errors.New("inline boom")
+	o := inlineOuter{Inner: validatedStruct{err: want}}
+	if err := validateValue(reflect.ValueOf(&o).Elem()); err != want {
+		t.Fatalf("got %v, want %v", err, want)
+	}
+}
+
+func TestValidateValueNoValidateTag(t *testing.T) {
+	o := novalidateOuter{Inner: validatedStruct{err: errors.New("should be skipped")}}
+	if err := validateValue(reflect.ValueOf(&o).Elem()); err != nil {
+		t.Fatalf("expected ,novalidate to skip the inline field, got: %v", err)
+	}
+}
+
+func TestSetValidationMode(t *testing.T) {
+	defer SetValidationMode(ValidateNone)
+
+	SetValidationMode(ValidateOnMarshal)
+	if got := currentValidationMode(); got != ValidateOnMarshal {
+		t.Fatalf("currentValidationMode() = %v, want ValidateOnMarshal", got)
+	}
+
+	SetValidationMode(ValidateBoth)
+	if got := currentValidationMode(); got&ValidateOnMarshal == 0 || got&ValidateOnUnmarshal == 0 {
+		t.Fatalf("currentValidationMode() = %v, want both bits set", got)
+	}
+}